@@ -0,0 +1,243 @@
+package libbuildpack
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// AgentProvider injects an APM agent into apps bound to a matching service.
+type AgentProvider interface {
+	// Name is the name of the agent's dependency in manifest.yml.
+	Name() string
+	Matches(service Service) bool
+	// LaunchEnv adds the env vars that configure the agent for service to
+	// script. agentDir is where the agent is installed, as seen at launch.
+	// Credentials should be exported with ProfileScript.ExportCredential,
+	// which reads them at launch instead of storing them in the droplet.
+	LaunchEnv(script *ProfileScript, service Service, agentDir string) error
+}
+
+// ServiceAgent is an AgentProvider for agents that are configured from the
+// credentials of their service.
+type ServiceAgent struct {
+	Dependency string
+	// Pattern is matched against the label, name and tags of services.
+	Pattern *regexp.Regexp
+	// HomeEnv, if set, is the env var that holds the agent dir.
+	HomeEnv string
+	// Credentials maps env vars to the credential paths they are set from at
+	// launch; the first path the service has wins. Credentials the service
+	// does not have while staging stay unset.
+	Credentials map[string][]string
+	Env         map[string]string
+}
+
+func (a *ServiceAgent) Name() string { return a.Dependency }
+
+func (a *ServiceAgent) Matches(service Service) bool {
+	if a.Pattern.MatchString(service.Label) || a.Pattern.MatchString(service.Name) {
+		return true
+	}
+	for _, tag := range service.Tags {
+		if a.Pattern.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *ServiceAgent) LaunchEnv(script *ProfileScript, service Service, agentDir string) error {
+	env := map[string]string{}
+	for name, value := range a.Env {
+		env[name] = value
+	}
+	if a.HomeEnv != "" {
+		env[a.HomeEnv] = agentDir
+	}
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		script.Export(name, env[name])
+	}
+
+	names = names[:0]
+	for name := range a.Credentials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, path := range a.Credentials[name] {
+			if _, found := service.CredentialString(path); found {
+				script.ExportCredential(name, service.Name, a.Credentials[name]...)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// DefaultAgentProviders returns the providers for the New Relic, Dynatrace
+// and AppDynamics agents.
+func DefaultAgentProviders() []AgentProvider {
+	return []AgentProvider{
+		&ServiceAgent{
+			Dependency: "newrelic",
+			Pattern:    regexp.MustCompile(`(?i)new-?relic`),
+			HomeEnv:    "NEW_RELIC_AGENT_DIR",
+			Credentials: map[string][]string{
+				"NEW_RELIC_LICENSE_KEY": {"licenseKey", "license_key"},
+			},
+		},
+		&ServiceAgent{
+			Dependency: "dynatrace",
+			Pattern:    regexp.MustCompile(`(?i)dynatrace`),
+			HomeEnv:    "DYNATRACE_AGENT_DIR",
+			Credentials: map[string][]string{
+				"DT_TENANT":    {"environmentid"},
+				"DT_API_TOKEN": {"apitoken"},
+				"DT_API_URL":   {"apiurl"},
+			},
+		},
+		&ServiceAgent{
+			Dependency: "appdynamics",
+			Pattern:    regexp.MustCompile(`(?i)app-?dynamics`),
+			HomeEnv:    "APPDYNAMICS_AGENT_DIR",
+			Credentials: map[string][]string{
+				"APPDYNAMICS_CONTROLLER_HOST_NAME":     {"host-name"},
+				"APPDYNAMICS_CONTROLLER_PORT":          {"port"},
+				"APPDYNAMICS_CONTROLLER_SSL_ENABLED":   {"ssl-enabled"},
+				"APPDYNAMICS_AGENT_ACCOUNT_NAME":       {"account-name"},
+				"APPDYNAMICS_AGENT_ACCOUNT_ACCESS_KEY": {"account-access-key"},
+			},
+		},
+	}
+}
+
+// AgentHook injects APM agents after supply. For every provider that matches
+// a bound service, it installs the agent into <DepDir>/agents/<name>, and
+// writes a profile.d script with its launch env. The agent's version is its
+// default_versions entry in the manifest if it has one, or else the highest
+// version in the manifest. Buildpacks opt in by adding the hook, e.g. with
+// stager.Hooks().Add(NewAgentHook(installer)).
+type AgentHook struct {
+	DefaultHook
+	installer *Installer
+
+	lock      sync.Mutex
+	providers []AgentProvider
+}
+
+// NewAgentHook returns an AgentHook with the DefaultAgentProviders.
+func NewAgentHook(installer *Installer) *AgentHook {
+	hook := &AgentHook{installer: installer}
+	for _, provider := range DefaultAgentProviders() {
+		hook.AddProvider(provider)
+	}
+	return hook
+}
+
+func (h *AgentHook) Name() string { return "agents" }
+
+// AddProvider adds provider, replacing the provider with the same name if
+// there is one.
+func (h *AgentHook) AddProvider(provider AgentProvider) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for idx, existing := range h.providers {
+		if existing.Name() == provider.Name() {
+			h.providers[idx] = provider
+			return
+		}
+	}
+	h.providers = append(h.providers, provider)
+}
+
+func (h *AgentHook) RemoveProvider(name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var providers []AgentProvider
+	for _, provider := range h.providers {
+		if provider.Name() != name {
+			providers = append(providers, provider)
+		}
+	}
+	h.providers = providers
+}
+
+func (h *AgentHook) Providers() []AgentProvider {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return append([]AgentProvider{}, h.providers...)
+}
+
+func (h *AgentHook) AfterSupply(stager *Stager) error {
+	return h.Inject(stager)
+}
+
+// Inject injects the agents of the services bound to the app.
+func (h *AgentHook) Inject(stager *Stager) error {
+	services, err := LoadServices()
+	if err != nil {
+		return err
+	}
+
+	for _, provider := range h.Providers() {
+		matched := services.Filter(provider.Matches)
+		if len(matched) == 0 {
+			continue
+		}
+
+		if err := h.inject(stager, provider, matched); err != nil {
+			return fmt.Errorf("could not inject the %s agent: %v", provider.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (h *AgentHook) inject(stager *Stager, provider AgentProvider, services Services) error {
+	name := provider.Name()
+	service := services[0]
+	if len(services) > 1 {
+		stager.log.Warning("Found %d services for the %s agent, using %s", len(services), name, service.Name)
+	}
+
+	if len(h.installer.manifest.AllDependencyVersions(name)) == 0 {
+		stager.log.Warning("Service %s needs the %s agent, which this buildpack does not provide", service.Name, name)
+		return nil
+	}
+
+	stager.log.BeginStep("Injecting %s agent for service %s", name, service.Name)
+	if _, err := h.installer.InstallMatching(name, h.agentVersion(name), filepath.Join(stager.DepDir(), "agents", name)); err != nil {
+		return err
+	}
+
+	script := NewProfileScript(name + "-agent")
+	if err := provider.LaunchEnv(script, service, filepath.Join("${DEPS_DIR}", stager.DepsIdx(), "agents", name)); err != nil {
+		return err
+	}
+	return stager.WriteProfileScript(script)
+}
+
+// agentVersion returns the version constraint of the agent name: its
+// default_versions entry, or else one that every version matches, so that
+// InstallMatching picks the highest.
+func (h *AgentHook) agentVersion(name string) string {
+	for _, dep := range h.installer.manifest.DefaultVersions {
+		if dep.Name == name {
+			return dep.Version
+		}
+	}
+	return anyVersion
+}
+
+// anyVersion is the version constraint that every version matches.
+const anyVersion = "x"
@@ -0,0 +1,210 @@
+package libbuildpack_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	httpmock "github.com/jarcoal/httpmock"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AgentHook", func() {
+	var (
+		buildDir string
+		depsDir  string
+		buffer   *bytes.Buffer
+		stager   *libbuildpack.Stager
+		hook     *libbuildpack.AgentHook
+	)
+
+	bindServices := func(services ...libbuildpack.Service) {
+		vcapServices, err := libbuildpack.NewVCAPServices(services...)
+		Expect(err).To(BeNil())
+		os.Setenv("VCAP_SERVICES", vcapServices)
+	}
+
+	profileScript := func(name string) string {
+		if runtime.GOOS == "windows" {
+			name += ".bat"
+		} else {
+			name += ".sh"
+		}
+		contents, err := os.ReadFile(filepath.Join(depsDir, "2", "profile.d", name))
+		Expect(err).To(BeNil())
+		return string(contents)
+	}
+
+	BeforeEach(func() {
+		for _, name := range []string{"VCAP_SERVICES", "SERVICE_BINDING_ROOT", "CF_STACK"} {
+			DeferCleanup(os.Setenv, name, os.Getenv(name))
+			os.Unsetenv(name)
+		}
+		os.Setenv("CF_STACK", "cflinuxfs2")
+		httpmock.Reset()
+
+		tmpDir, err := os.MkdirTemp("", "agents")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, tmpDir)
+		buildDir = filepath.Join(tmpDir, "build")
+		depsDir = filepath.Join(tmpDir, "deps")
+		manifestDir := filepath.Join(tmpDir, "buildpack")
+		for _, dir := range []string{buildDir, filepath.Join(depsDir, "2"), manifestDir} {
+			Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+		}
+
+		var entries []libbuildpack.ManifestEntry
+		for _, version := range []string{"7.1.0", "8.0.0"} {
+			entries = append(entries, libbuildpack.ManifestEntry{
+				Dependency: libbuildpack.Dependency{Name: "newrelic", Version: version},
+				URI:        "https://example.com/dependencies/newrelic-" + version + ".tgz",
+				SHA256:     "8208480eb849203632239f73bd3c61ed488546d19d29c06d7c2e1649d8950bd1",
+				CFStacks:   []string{"cflinuxfs2"},
+			})
+		}
+		Expect(libbuildpack.NewYAML().Write(filepath.Join(manifestDir, "manifest.yml"), libbuildpack.Manifest{LanguageString: "sample", ManifestEntries: entries})).To(Succeed())
+
+		tgzContents, err := os.ReadFile("fixtures/thing.tgz")
+		Expect(err).To(BeNil())
+		httpmock.RegisterResponder("GET", "https://example.com/dependencies/newrelic-8.0.0.tgz",
+			httpmock.NewStringResponder(200, string(tgzContents)))
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(buffer)
+		manifest, err := libbuildpack.NewManifest(manifestDir, logger, time.Now())
+		Expect(err).To(BeNil())
+		stager = libbuildpack.NewStager([]string{buildDir, "", depsDir, "2"}, logger, manifest)
+		installer := libbuildpack.NewInstaller(manifest)
+		installer.SetRetryTimeLimit(10 * time.Millisecond)
+		installer.SetRetryTimeInitialInterval(1 * time.Millisecond)
+		hook = libbuildpack.NewAgentHook(installer)
+	})
+
+	It("installs the agent of a matching service and writes its launch env", func() {
+		bindServices(libbuildpack.Service{
+			Name:        "monitoring",
+			Label:       "newrelic",
+			Credentials: map[string]interface{}{"licenseKey": "abc123"},
+		})
+
+		Expect(hook.AfterSupply(stager)).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Injecting newrelic agent for service monitoring"))
+		Expect(filepath.Join(depsDir, "2", "agents", "newrelic", "thing", "bin", "file2.exe")).To(BeAnExistingFile())
+
+		script := profileScript("newrelic-agent")
+		Expect(script).To(ContainSubstring("NEW_RELIC_LICENSE_KEY"))
+		Expect(script).To(ContainSubstring("NEW_RELIC_AGENT_DIR"))
+		Expect(script).To(ContainSubstring(filepath.Join("2", "agents", "newrelic")))
+	})
+
+	It("reads credentials from the service at launch instead of storing them", func() {
+		if runtime.GOOS == "windows" {
+			Skip("profile.d scripts are sourced by sh on Linux only")
+		}
+		if _, err := exec.LookPath("jq"); err != nil {
+			Skip("needs jq")
+		}
+		service := libbuildpack.Service{
+			Name:        "monitoring",
+			Label:       "newrelic",
+			Credentials: map[string]interface{}{"licenseKey": "0123456789abcdef"},
+		}
+		bindServices(service)
+
+		Expect(hook.AfterSupply(stager)).To(Succeed())
+		Expect(profileScript("newrelic-agent")).NotTo(ContainSubstring("0123456789abcdef"))
+
+		service.Credentials["licenseKey"] = "rotated-license-key"
+		vcapServices, err := libbuildpack.NewVCAPServices(service)
+		Expect(err).To(BeNil())
+		cmd := exec.Command("sh", "-c", `. "$1" && printf '%s|%s' "$NEW_RELIC_LICENSE_KEY" "$NEW_RELIC_AGENT_DIR"`, "--", filepath.Join(depsDir, "2", "profile.d", "newrelic-agent.sh"))
+		cmd.Env = []string{"DEPS_DIR=/home/vcap/deps", "PATH=" + os.Getenv("PATH"), "VCAP_SERVICES=" + vcapServices}
+		output, err := cmd.Output()
+		Expect(err).To(BeNil())
+		Expect(string(output)).To(Equal("rotated-license-key|/home/vcap/deps/2/agents/newrelic"))
+	})
+
+	It("installs the default version of the agent", func() {
+		manifestFile := filepath.Join(filepath.Dir(depsDir), "buildpack", "manifest.yml")
+		var manifest libbuildpack.Manifest
+		Expect(libbuildpack.NewYAML().Load(manifestFile, &manifest)).To(Succeed())
+		manifest.DefaultVersions = []libbuildpack.Dependency{{Name: "newrelic", Version: "7.1.x"}}
+		Expect(libbuildpack.NewYAML().Write(manifestFile, manifest)).To(Succeed())
+
+		tgzContents, err := os.ReadFile("fixtures/thing.tgz")
+		Expect(err).To(BeNil())
+		httpmock.RegisterResponder("GET", "https://example.com/dependencies/newrelic-7.1.0.tgz",
+			httpmock.NewStringResponder(200, string(tgzContents)))
+
+		logger := libbuildpack.NewLogger(buffer)
+		loaded, err := libbuildpack.NewManifest(filepath.Dir(manifestFile), logger, time.Now())
+		Expect(err).To(BeNil())
+		hook = libbuildpack.NewAgentHook(libbuildpack.NewInstaller(loaded))
+		bindServices(libbuildpack.Service{Name: "monitoring", Label: "newrelic"})
+
+		Expect(hook.Inject(stager)).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Installing newrelic 7.1.0"))
+	})
+
+	It("matches services by tag", func() {
+		bindServices(libbuildpack.Service{Name: "apm", Label: "user-provided", Tags: []string{"newrelic"}})
+
+		Expect(hook.Inject(stager)).To(Succeed())
+		Expect(filepath.Join(depsDir, "2", "agents", "newrelic")).To(BeADirectory())
+	})
+
+	It("does nothing without a matching service", func() {
+		bindServices(libbuildpack.Service{Name: "orders-db", Label: "postgres"})
+
+		Expect(hook.Inject(stager)).To(Succeed())
+		Expect(buffer.String()).To(BeEmpty())
+		Expect(filepath.Join(depsDir, "2", "agents")).NotTo(BeADirectory())
+	})
+
+	It("warns when the buildpack does not provide the agent", func() {
+		bindServices(libbuildpack.Service{Name: "dt", Label: "dynatrace"})
+
+		Expect(hook.Inject(stager)).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Service dt needs the dynatrace agent, which this buildpack does not provide"))
+	})
+
+	It("fails when the agent cannot be installed", func() {
+		httpmock.RegisterResponder("GET", "https://example.com/dependencies/newrelic-8.0.0.tgz", httpmock.NewStringResponder(404, "not found"))
+		httpmock.RegisterResponder("GET", "https://example.com/dependencies/newrelic-7.1.0.tgz", httpmock.NewStringResponder(404, "not found"))
+		bindServices(libbuildpack.Service{Name: "monitoring", Label: "newrelic"})
+
+		Expect(hook.Inject(stager)).To(MatchError(ContainSubstring("could not inject the newrelic agent")))
+	})
+
+	It("uses registered providers in place of the defaults", func() {
+		hook.AddProvider(&libbuildpack.ServiceAgent{
+			Dependency: "newrelic",
+			Pattern:    regexp.MustCompile(`^apm$`),
+			Env:        map[string]string{"CUSTOM_AGENT": "true"},
+		})
+		bindServices(
+			libbuildpack.Service{Name: "monitoring", Label: "newrelic"},
+			libbuildpack.Service{Name: "apm", Label: "user-provided"},
+		)
+
+		Expect(hook.Providers()).To(HaveLen(3))
+		Expect(hook.Inject(stager)).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("for service apm"))
+		Expect(profileScript("newrelic-agent")).To(ContainSubstring("CUSTOM_AGENT"))
+	})
+
+	It("does not inject removed providers", func() {
+		hook.RemoveProvider("newrelic")
+		bindServices(libbuildpack.Service{Name: "monitoring", Label: "newrelic"})
+
+		Expect(hook.Inject(stager)).To(Succeed())
+		Expect(buffer.String()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,178 @@
+package libbuildpack
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Cache is a named part of the app cache, e.g. node_modules or a Maven repo.
+// Its contents are only restored if the fingerprint of its inputs, such as
+// lockfile hashes and dependency versions, matches the one it was stored with.
+type Cache struct {
+	key      string
+	dir      string
+	log      *Logger
+	manifest *Manifest
+	inputs   map[string]string
+}
+
+type cacheMetadata struct {
+	Fingerprint string            `yaml:"fingerprint"`
+	Inputs      map[string]string `yaml:"inputs"`
+}
+
+var cacheKeyRe = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// Cache returns the cache stored under key in <CacheDir>/caches.
+func (s *Stager) Cache(key string) (*Cache, error) {
+	if !cacheKeyRe.MatchString(key) {
+		return nil, fmt.Errorf("invalid cache key %q", key)
+	}
+
+	return &Cache{
+		key:      key,
+		dir:      filepath.Join(s.cacheDir, "caches", key),
+		log:      s.log,
+		manifest: s.manifest,
+		inputs:   map[string]string{},
+	}, nil
+}
+
+// AddInput adds a value the cache contents depend on, e.g. a runtime version.
+func (c *Cache) AddInput(name, value string) {
+	c.inputs[name] = value
+}
+
+// AddFileInput adds the sha256 of file, e.g. a lockfile, as an input. A
+// missing file is recorded as an empty input.
+func (c *Cache) AddFileInput(name, file string) error {
+	fh, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.inputs[name] = ""
+			return nil
+		}
+		return err
+	}
+	defer fh.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, fh); err != nil {
+		return err
+	}
+	c.inputs[name] = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// Fingerprint is the sha256 of the inputs added so far.
+func (c *Cache) Fingerprint() string {
+	names := make([]string, 0, len(c.inputs))
+	for name := range c.inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(hash, "%s\x00%s\x00", name, c.inputs[name])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Restore copies the cache contents into targetDir and returns true if the
+// cache was stored with the current fingerprint. A stale cache is evicted.
+// In plan mode it only records whether the cache would be restored.
+func (c *Cache) Restore(targetDir string) (bool, error) {
+	var metadata cacheMetadata
+	err := NewYAML().Load(c.metadataPath(), &metadata)
+	if Planning() {
+		hit := err == nil && metadata.Fingerprint == c.Fingerprint()
+		return hit, c.manifest.recordPlanAction(PlanRestoreCache, targetDir, map[string]string{
+			"key":         c.key,
+			"fingerprint": c.Fingerprint(),
+			"hit":         strconv.FormatBool(hit),
+		})
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		c.log.Warning("Could not read metadata of cache %s: %s", c.key, err)
+		return false, c.Clear()
+	}
+
+	if metadata.Fingerprint != c.Fingerprint() {
+		c.log.Info("Evicting cache %s: %s changed", c.key, strings.Join(changedInputs(metadata.Inputs, c.inputs), ", "))
+		return false, c.Clear()
+	}
+
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return false, err
+	}
+	if err := CopyDirectory(c.contentsDir(), targetDir); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Store replaces the cache contents with those of sourceDir, recording the
+// current fingerprint.
+func (c *Cache) Store(sourceDir string) error {
+	if Planning() {
+		return c.manifest.recordPlanAction(PlanStoreCache, c.contentsDir(), map[string]string{
+			"key":         c.key,
+			"fingerprint": c.Fingerprint(),
+			"source":      sourceDir,
+		})
+	}
+
+	if err := c.Clear(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.contentsDir(), 0755); err != nil {
+		return err
+	}
+	if err := CopyDirectory(sourceDir, c.contentsDir()); err != nil {
+		return err
+	}
+
+	return NewYAML().Write(c.metadataPath(), cacheMetadata{Fingerprint: c.Fingerprint(), Inputs: c.inputs})
+}
+
+func (c *Cache) Clear() error {
+	return os.RemoveAll(c.dir)
+}
+
+func (c *Cache) contentsDir() string {
+	return filepath.Join(c.dir, "contents")
+}
+
+func (c *Cache) metadataPath() string {
+	return filepath.Join(c.dir, "metadata.yml")
+}
+
+func changedInputs(old, current map[string]string) []string {
+	var changed []string
+	for name, value := range current {
+		if oldValue, found := old[name]; !found || oldValue != value {
+			changed = append(changed, name)
+		}
+	}
+	for name := range old {
+		if _, found := current[name]; !found {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,141 @@
+package libbuildpack_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cache", func() {
+	var (
+		buildDir string
+		cacheDir string
+		buffer   *bytes.Buffer
+		s        *libbuildpack.Stager
+		err      error
+	)
+
+	BeforeEach(func() {
+		buildDir, err = os.MkdirTemp("", "build")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, buildDir)
+
+		cacheDir, err = os.MkdirTemp("", "cache")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, cacheDir)
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(buffer)
+		manifest, err := libbuildpack.NewManifest(filepath.Join("fixtures", "manifest", "standard"), logger, time.Now())
+		Expect(err).To(BeNil())
+
+		s = libbuildpack.NewStager([]string{buildDir, cacheDir, "", ""}, logger, manifest)
+
+		Expect(os.WriteFile(filepath.Join(buildDir, "package-lock.json"), []byte(`{"lockfileVersion": 3}`), 0644)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(buildDir, "node_modules", "left-pad"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(buildDir, "node_modules", "left-pad", "index.js"), []byte("module.exports = 1"), 0644)).To(Succeed())
+	})
+
+	newCache := func(nodeVersion string) *libbuildpack.Cache {
+		cache, err := s.Cache("node_modules")
+		Expect(err).To(BeNil())
+		cache.AddInput("node", nodeVersion)
+		Expect(cache.AddFileInput("package-lock.json", filepath.Join(buildDir, "package-lock.json"))).To(Succeed())
+		return cache
+	}
+
+	It("rejects keys that are not a single path element", func() {
+		for _, key := range []string{"", "..", "a/b", ".hidden"} {
+			_, err := s.Cache(key)
+			Expect(err).To(MatchError(ContainSubstring("invalid cache key")), key)
+		}
+	})
+
+	It("restores nothing when nothing was stored", func() {
+		restored, err := newCache("18.0.0").Restore(filepath.Join(buildDir, "restored"))
+		Expect(err).To(BeNil())
+		Expect(restored).To(BeFalse())
+		Expect(filepath.Join(buildDir, "restored")).NotTo(BeADirectory())
+	})
+
+	Context("contents were stored", func() {
+		BeforeEach(func() {
+			Expect(newCache("18.0.0").Store(filepath.Join(buildDir, "node_modules"))).To(Succeed())
+			Expect(os.RemoveAll(filepath.Join(buildDir, "node_modules"))).To(Succeed())
+		})
+
+		It("stores them under <cacheDir>/caches/<key>", func() {
+			Expect(filepath.Join(cacheDir, "caches", "node_modules", "contents", "left-pad", "index.js")).To(BeAnExistingFile())
+			Expect(filepath.Join(cacheDir, "caches", "node_modules", "metadata.yml")).To(BeAnExistingFile())
+		})
+
+		It("restores them when the fingerprint matches", func() {
+			restored, err := newCache("18.0.0").Restore(filepath.Join(buildDir, "node_modules"))
+			Expect(err).To(BeNil())
+			Expect(restored).To(BeTrue())
+
+			Expect(os.ReadFile(filepath.Join(buildDir, "node_modules", "left-pad", "index.js"))).To(Equal([]byte("module.exports = 1")))
+		})
+
+		It("evicts them when an input changes", func() {
+			restored, err := newCache("20.0.0").Restore(filepath.Join(buildDir, "node_modules"))
+			Expect(err).To(BeNil())
+			Expect(restored).To(BeFalse())
+
+			Expect(buffer.String()).To(ContainSubstring("Evicting cache node_modules: node changed"))
+			Expect(filepath.Join(buildDir, "node_modules")).NotTo(BeADirectory())
+			Expect(filepath.Join(cacheDir, "caches", "node_modules")).NotTo(BeADirectory())
+		})
+
+		It("evicts them when a file input changes", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "package-lock.json"), []byte(`{"lockfileVersion": 2}`), 0644)).To(Succeed())
+
+			restored, err := newCache("18.0.0").Restore(filepath.Join(buildDir, "node_modules"))
+			Expect(err).To(BeNil())
+			Expect(restored).To(BeFalse())
+			Expect(buffer.String()).To(ContainSubstring("Evicting cache node_modules: package-lock.json changed"))
+		})
+
+		It("replaces them on the next store", func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "new_modules"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "new_modules", "other.js"), []byte("2"), 0644)).To(Succeed())
+			Expect(newCache("18.0.0").Store(filepath.Join(buildDir, "new_modules"))).To(Succeed())
+
+			restored, err := newCache("18.0.0").Restore(filepath.Join(buildDir, "node_modules"))
+			Expect(err).To(BeNil())
+			Expect(restored).To(BeTrue())
+			Expect(filepath.Join(buildDir, "node_modules", "other.js")).To(BeAnExistingFile())
+			Expect(filepath.Join(buildDir, "node_modules", "left-pad")).NotTo(BeADirectory())
+		})
+
+		It("is removed by ClearCache", func() {
+			Expect(s.ClearCache()).To(Succeed())
+
+			restored, err := newCache("18.0.0").Restore(filepath.Join(buildDir, "node_modules"))
+			Expect(err).To(BeNil())
+			Expect(restored).To(BeFalse())
+		})
+	})
+
+	It("fingerprints inputs independently of the order they were added", func() {
+		a, err := s.Cache("a")
+		Expect(err).To(BeNil())
+		a.AddInput("x", "1")
+		a.AddInput("y", "2")
+
+		b, err := s.Cache("b")
+		Expect(err).To(BeNil())
+		b.AddInput("y", "2")
+		b.AddInput("x", "1")
+
+		Expect(a.Fingerprint()).To(Equal(b.Fingerprint()))
+		b.AddInput("x", "3")
+		Expect(a.Fingerprint()).NotTo(Equal(b.Fingerprint()))
+	})
+})
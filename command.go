@@ -1,36 +1,144 @@
 package libbuildpack
 
 import (
+	"context"
+	"errors"
 	"io"
 	"os"
 	"os/exec"
+	"reflect"
+	"strings"
+	"time"
 )
 
 type Command struct {
+	// Redactor masks secrets in the output of Execute. The package wide
+	// redactor is used if it is nil.
+	Redactor *Redactor
 }
 
 func (c *Command) Execute(dir string, stdout io.Writer, stderr io.Writer, program string, args ...string) error {
-	cmd := exec.Command(program, args...)
+	return c.ExecuteContext(context.Background(), dir, stdout, stderr, program, args...)
+}
+
+// ExecuteContext is Execute, but kills the program when ctx is done or the
+// budget set in CommandTimeoutEnv runs out. Both run the program with
+// registered secrets and known patterns masked in what it writes to stdout and
+// stderr.
+func (c *Command) ExecuteContext(ctx context.Context, dir string, stdout io.Writer, stderr io.Writer, program string, args ...string) error {
+	ctx, cancel, op, err := startOperation(ctx, commandName(program, args), CommandTimeoutEnv)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, program, args...)
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 	cmd.Dir = dir
+	// Background processes that keep the output open do not hold up Execute
+	// for longer than this once the program exits.
+	cmd.WaitDelay = time.Second
 
-	return cmd.Run()
+	redactor := c.redactor()
+	if stdout != nil {
+		w := redactor.Writer(stdout)
+		defer w.Close()
+		cmd.Stdout = w
+	}
+	if sameWriter(stdout, stderr) {
+		cmd.Stderr = cmd.Stdout
+	} else if stderr != nil {
+		w := redactor.Writer(stderr)
+		defer w.Close()
+		cmd.Stderr = w
+	}
+
+	err = cmd.Run()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// The program succeeded, and left the output to its children.
+		err = nil
+	}
+	return op.result(ctx, err)
 }
 
 func (c *Command) Output(dir string, program string, args ...string) (string, error) {
-	cmd := exec.Command(program, args...)
+	return c.OutputContext(context.Background(), dir, program, args...)
+}
+
+// OutputContext is Output, but kills the program when ctx is done or the
+// budget set in CommandTimeoutEnv runs out.
+func (c *Command) OutputContext(ctx context.Context, dir string, program string, args ...string) (string, error) {
+	ctx, cancel, op, err := startOperation(ctx, commandName(program, args), CommandTimeoutEnv)
+	if err != nil {
+		return "", err
+	}
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, program, args...)
 	cmd.Stderr = os.Stderr // TODO remove this line
 	cmd.Dir = dir
 
 	output, err := cmd.Output()
-	return string(output), err
+	return string(output), op.result(ctx, err)
 }
 
 func (c *Command) Run(cmd *exec.Cmd) error {
 	return cmd.Run()
 }
 
+// RunContext runs cmd, and kills it when ctx is done or the budget set in
+// CommandTimeoutEnv runs out.
+func (c *Command) RunContext(ctx context.Context, cmd *exec.Cmd) error {
+	var args []string
+	if len(cmd.Args) > 1 {
+		args = cmd.Args[1:]
+	}
+
+	ctx, cancel, op, err := startOperation(ctx, commandName(cmd.Path, args), CommandTimeoutEnv)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		return op.result(ctx, err)
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-done
+		return op.result(ctx, ctx.Err())
+	}
+}
+
 func (c *Command) RunWithOutput(cmd *exec.Cmd) ([]byte, error) {
 	return cmd.Output()
 }
+
+func (c *Command) redactor() *Redactor {
+	if c.Redactor == nil {
+		return globalRedactor
+	}
+	return c.Redactor
+}
+
+// sameWriter reports whether a and b are the same pointer, without comparing
+// the interfaces, which panics for writers of uncomparable types.
+func sameWriter(a, b io.Writer) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	return va.Type() == vb.Type() && va.Kind() == reflect.Pointer && va.Pointer() == vb.Pointer()
+}
+
+func commandName(program string, args []string) string {
+	return "command " + strings.TrimSpace(program+" "+strings.Join(args, " "))
+}
@@ -0,0 +1,154 @@
+package libbuildpack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// DetectCheck inspects buildDir and reports whether the app matches, with a
+// human readable reason either way.
+type DetectCheck func(buildDir string) (bool, string, error)
+
+// Detector evaluates checks in the order they were added. Detection passes as
+// soon as one check passes.
+type Detector struct {
+	checks []DetectCheck
+}
+
+type DetectResult struct {
+	Pass    bool
+	Reasons []string
+}
+
+func NewDetector() *Detector {
+	return &Detector{}
+}
+
+func (d *Detector) Add(check DetectCheck) {
+	d.checks = append(d.checks, check)
+}
+
+func (d *Detector) Detect(buildDir string) (DetectResult, error) {
+	var result DetectResult
+
+	for _, check := range d.checks {
+		pass, reason, err := check(buildDir)
+		if err != nil {
+			return result, err
+		}
+		result.Reasons = append(result.Reasons, reason)
+		if pass {
+			result.Pass = true
+			return result, nil
+		}
+	}
+
+	return result, nil
+}
+
+// FileCheck passes if a file in the build dir matches the glob pattern.
+func FileCheck(pattern string) DetectCheck {
+	return func(buildDir string) (bool, string, error) {
+		matches, err := filepath.Glob(filepath.Join(buildDir, pattern))
+		if err != nil {
+			return false, "", fmt.Errorf("invalid detect pattern %s: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return false, fmt.Sprintf("no file matching %s", pattern), nil
+		}
+		return true, fmt.Sprintf("found %s", relativePath(buildDir, matches[0])), nil
+	}
+}
+
+// ContentCheck passes if a file in the build dir matching the glob pattern
+// has contents matching re.
+func ContentCheck(pattern string, re *regexp.Regexp) DetectCheck {
+	return func(buildDir string) (bool, string, error) {
+		matches, err := filepath.Glob(filepath.Join(buildDir, pattern))
+		if err != nil {
+			return false, "", fmt.Errorf("invalid detect pattern %s: %v", pattern, err)
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return false, "", err
+			}
+			if !info.Mode().IsRegular() {
+				continue
+			}
+
+			content, err := os.ReadFile(match)
+			if err != nil {
+				return false, "", err
+			}
+			if re.Match(content) {
+				return true, fmt.Sprintf("%s matches %s", relativePath(buildDir, match), re), nil
+			}
+		}
+		return false, fmt.Sprintf("no file matching %s matches %s", pattern, re), nil
+	}
+}
+
+// JSONKeyCheck passes if file is a JSON object in the build dir with a
+// top-level key, e.g. the engines field of package.json.
+func JSONKeyCheck(file, key string) DetectCheck {
+	return func(buildDir string) (bool, string, error) {
+		data, err := os.ReadFile(filepath.Join(buildDir, file))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return false, fmt.Sprintf("no %s", file), nil
+			}
+			return false, "", err
+		}
+
+		var obj map[string]interface{}
+		if err := json.Unmarshal(removeBOM(data), &obj); err != nil {
+			return false, fmt.Sprintf("%s is not a JSON object", file), nil
+		}
+		if _, found := obj[key]; !found {
+			return false, fmt.Sprintf("%s has no %s field", file, key), nil
+		}
+		return true, fmt.Sprintf("%s has the %s field", file, key), nil
+	}
+}
+
+// RunDetect runs detector against buildDir for bin/detect and returns its exit
+// code: 0 if the app was detected, 1 if not or if detection failed. On a pass
+// the buildpack name and version are printed to stdout; reasons are logged.
+func RunDetect(buildDir string, manifest *Manifest, detector *Detector, stdout io.Writer, logger *Logger) int {
+	result, err := detector.Detect(buildDir)
+	if err != nil {
+		logger.Error("Unable to detect: %s", err)
+		return 1
+	}
+
+	for _, reason := range result.Reasons {
+		logger.Info("%s", reason)
+	}
+
+	if !result.Pass {
+		return 1
+	}
+
+	version, err := manifest.Version()
+	if err != nil {
+		logger.Error("Unable to determine buildpack version: %s", err)
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "%s %s\n", manifest.Language(), version)
+	return 0
+}
+
+func relativePath(base, path string) string {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
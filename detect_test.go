@@ -0,0 +1,181 @@
+package libbuildpack_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Detector", func() {
+	var (
+		buildDir string
+		detector *libbuildpack.Detector
+		err      error
+	)
+
+	BeforeEach(func() {
+		buildDir, err = os.MkdirTemp("", "build")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, buildDir)
+
+		detector = libbuildpack.NewDetector()
+	})
+
+	Describe("FileCheck", func() {
+		BeforeEach(func() {
+			detector.Add(libbuildpack.FileCheck("*.csproj"))
+		})
+
+		It("passes when a file matches", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "app.csproj"), []byte(""), 0644)).To(Succeed())
+
+			Expect(detector.Detect(buildDir)).To(Equal(libbuildpack.DetectResult{Pass: true, Reasons: []string{"found app.csproj"}}))
+		})
+
+		It("fails when no file matches", func() {
+			Expect(detector.Detect(buildDir)).To(Equal(libbuildpack.DetectResult{Pass: false, Reasons: []string{"no file matching *.csproj"}}))
+		})
+	})
+
+	Describe("ContentCheck", func() {
+		BeforeEach(func() {
+			detector.Add(libbuildpack.ContentCheck("requirements*.txt", regexp.MustCompile(`(?m)^flask`)))
+		})
+
+		It("passes when a matching file has matching contents", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "requirements.txt"), []byte("requests\nflask==2.0\n"), 0644)).To(Succeed())
+
+			result, err := detector.Detect(buildDir)
+			Expect(err).To(BeNil())
+			Expect(result.Pass).To(BeTrue())
+			Expect(result.Reasons).To(Equal([]string{"requirements.txt matches (?m)^flask"}))
+		})
+
+		It("fails when the contents do not match", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "requirements.txt"), []byte("requests\n"), 0644)).To(Succeed())
+
+			result, err := detector.Detect(buildDir)
+			Expect(err).To(BeNil())
+			Expect(result.Pass).To(BeFalse())
+		})
+
+		It("skips directories matching the pattern", func() {
+			Expect(os.Mkdir(filepath.Join(buildDir, "requirements-dev.txt"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "requirements.txt"), []byte("flask==2.0\n"), 0644)).To(Succeed())
+
+			result, err := detector.Detect(buildDir)
+			Expect(err).To(BeNil())
+			Expect(result.Pass).To(BeTrue())
+		})
+	})
+
+	Describe("JSONKeyCheck", func() {
+		BeforeEach(func() {
+			detector.Add(libbuildpack.JSONKeyCheck("package.json", "engines"))
+		})
+
+		It("passes when the key is present", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"engines": {"node": "18.x"}}`), 0644)).To(Succeed())
+
+			Expect(detector.Detect(buildDir)).To(Equal(libbuildpack.DetectResult{Pass: true, Reasons: []string{"package.json has the engines field"}}))
+		})
+
+		It("fails when the key is missing", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`{"name": "app"}`), 0644)).To(Succeed())
+
+			Expect(detector.Detect(buildDir)).To(Equal(libbuildpack.DetectResult{Pass: false, Reasons: []string{"package.json has no engines field"}}))
+		})
+
+		It("fails when the file is not JSON", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "package.json"), []byte(`not json`), 0644)).To(Succeed())
+
+			Expect(detector.Detect(buildDir)).To(Equal(libbuildpack.DetectResult{Pass: false, Reasons: []string{"package.json is not a JSON object"}}))
+		})
+
+		It("returns errors reading the file", func() {
+			Expect(os.Mkdir(filepath.Join(buildDir, "package.json"), 0755)).To(Succeed())
+
+			_, err := detector.Detect(buildDir)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).NotTo(ContainSubstring("not a JSON object"))
+		})
+	})
+
+	Context("several checks", func() {
+		BeforeEach(func() {
+			detector.Add(libbuildpack.FileCheck("package.json"))
+			detector.Add(libbuildpack.FileCheck("server.js"))
+			detector.Add(libbuildpack.FileCheck("*.js"))
+		})
+
+		It("stops at the first passing check", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "server.js"), []byte(""), 0644)).To(Succeed())
+
+			result, err := detector.Detect(buildDir)
+			Expect(err).To(BeNil())
+			Expect(result.Pass).To(BeTrue())
+			Expect(result.Reasons).To(Equal([]string{"no file matching package.json", "found server.js"}))
+		})
+
+		It("collects the reasons of every failed check", func() {
+			result, err := detector.Detect(buildDir)
+			Expect(err).To(BeNil())
+			Expect(result.Pass).To(BeFalse())
+			Expect(result.Reasons).To(HaveLen(3))
+		})
+	})
+
+	It("returns errors from checks", func() {
+		detector.Add(libbuildpack.FileCheck("[bad"))
+
+		_, err := detector.Detect(buildDir)
+		Expect(err).To(MatchError(ContainSubstring("invalid detect pattern [bad")))
+	})
+
+	Describe("RunDetect", func() {
+		var (
+			manifest *libbuildpack.Manifest
+			logger   *libbuildpack.Logger
+			stdout   *bytes.Buffer
+			stderr   *bytes.Buffer
+		)
+
+		BeforeEach(func() {
+			stdout = new(bytes.Buffer)
+			stderr = new(bytes.Buffer)
+			logger = libbuildpack.NewLogger(stderr)
+			manifest, err = libbuildpack.NewManifest(filepath.Join("fixtures", "manifest", "standard"), logger, time.Now())
+			Expect(err).To(BeNil())
+
+			detector.Add(libbuildpack.FileCheck("*.csproj"))
+		})
+
+		It("prints the buildpack name and version and returns 0 on a pass", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "app.csproj"), []byte(""), 0644)).To(Succeed())
+
+			Expect(libbuildpack.RunDetect(buildDir, manifest, detector, stdout, logger)).To(Equal(0))
+			Expect(stdout.String()).To(Equal("dotnet-core 99.99\n"))
+			Expect(stderr.String()).To(ContainSubstring("found app.csproj"))
+		})
+
+		It("prints nothing and returns 1 on a fail", func() {
+			Expect(libbuildpack.RunDetect(buildDir, manifest, detector, stdout, logger)).To(Equal(1))
+			Expect(stdout.String()).To(BeEmpty())
+			Expect(stderr.String()).To(ContainSubstring("no file matching *.csproj"))
+		})
+
+		It("returns 1 when a check errors", func() {
+			detector.Add(libbuildpack.FileCheck("[bad"))
+
+			Expect(libbuildpack.RunDetect(buildDir, manifest, detector, stdout, logger)).To(Equal(1))
+			Expect(stderr.String()).To(ContainSubstring("Unable to detect"))
+		})
+	})
+})
@@ -0,0 +1,269 @@
+package libbuildpack
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiskLimitEnv names the env var holding the soft disk limit for staging, as
+// bytes or with a K, M or G suffix, e.g. 4G. When it is set, the Stager
+// measures disk usage after every top level Step and warns once the limit is
+// exceeded.
+const DiskLimitEnv = "BP_DISK_LIMIT"
+
+// DiskUsage is the size in bytes of the directories staging writes to.
+type DiskUsage struct {
+	BuildDir int64 `json:"build_dir"`
+	CacheDir int64 `json:"cache_dir"`
+	DepDir   int64 `json:"dep_dir"`
+}
+
+func (d DiskUsage) Total() int64 {
+	return d.BuildDir + d.CacheDir + d.DepDir
+}
+
+// DiskLimit returns the soft disk limit set in DiskLimitEnv, or 0 if there is
+// none.
+func DiskLimit() (int64, error) {
+	value := os.Getenv(DiskLimitEnv)
+	if value == "" {
+		return 0, nil
+	}
+
+	limit, err := parseByteSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", DiskLimitEnv, err)
+	}
+	return limit, nil
+}
+
+// DirSize returns the size of the regular files under dir. A missing dir has
+// size 0.
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+func (s *Stager) DiskUsage() (DiskUsage, error) {
+	var usage DiskUsage
+	for _, dir := range []struct {
+		path string
+		size *int64
+	}{
+		{s.buildDir, &usage.BuildDir},
+		{s.cacheDir, &usage.CacheDir},
+		{s.DepDir(), &usage.DepDir},
+	} {
+		if dir.path == "" {
+			continue
+		}
+		size, err := DirSize(dir.path)
+		if err != nil {
+			return DiskUsage{}, err
+		}
+		*dir.size = size
+	}
+	return usage, nil
+}
+
+// CheckDiskUsage measures the disk usage of staging and warns, with the
+// largest directories, if it exceeds the limit set in DiskLimitEnv.
+func (s *Stager) CheckDiskUsage() (DiskUsage, error) {
+	usage, _, err := s.checkDiskUsage()
+	return usage, err
+}
+
+func (s *Stager) checkDiskUsage() (DiskUsage, bool, error) {
+	limit, err := DiskLimit()
+	if err != nil {
+		return DiskUsage{}, false, err
+	}
+
+	usage, err := s.DiskUsage()
+	if err != nil {
+		return DiskUsage{}, false, err
+	}
+
+	if limit == 0 || usage.Total() <= limit {
+		return usage, false, nil
+	}
+
+	largest, err := s.largestSubdirs(5)
+	if err != nil {
+		return DiskUsage{}, false, err
+	}
+
+	msg := fmt.Sprintf("Staging uses %s of disk, over the limit of %s set in %s\nLargest directories:", formatBytes(usage.Total()), formatBytes(limit), DiskLimitEnv)
+	for _, dir := range largest {
+		msg += fmt.Sprintf("\n  %9s  %s", formatBytes(dir.size), dir.path)
+	}
+	s.log.Warning("%s", msg)
+
+	return usage, true, nil
+}
+
+type dirSize struct {
+	path string
+	size int64
+}
+
+func (s *Stager) largestSubdirs(count int) ([]dirSize, error) {
+	var sizes []dirSize
+
+	for _, dir := range []string{s.buildDir, s.cacheDir, s.DepDir()} {
+		if dir == "" {
+			continue
+		}
+
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		for _, file := range files {
+			path := filepath.Join(dir, file.Name())
+			size, err := DirSize(path)
+			if err != nil {
+				return nil, err
+			}
+			sizes = append(sizes, dirSize{path: path, size: size})
+		}
+	}
+
+	sort.SliceStable(sizes, func(i, j int) bool { return sizes[i].size > sizes[j].size })
+	if len(sizes) > count {
+		sizes = sizes[:count]
+	}
+	return sizes, nil
+}
+
+// ArchiveExtractedSize estimates the bytes archive takes up once extracted.
+// Zip files and gzipped tarballs record their uncompressed size; for other
+// files the size of the file itself is used as a lower bound.
+func ArchiveExtractedSize(archive string) (int64, error) {
+	return archiveExtractedSize(archive, archive)
+}
+
+// archiveExtractedSize is ArchiveExtractedSize for an archive whose type is
+// given by the suffix of name, e.g. the URI it was downloaded from.
+func archiveExtractedSize(archive, name string) (int64, error) {
+	info, err := os.Stat(archive)
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		r, err := zip.OpenReader(archive)
+		if err != nil {
+			return 0, err
+		}
+		defer r.Close()
+
+		var total int64
+		for _, f := range r.File {
+			total += int64(f.UncompressedSize64)
+		}
+		return total, nil
+
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		if size < 4 {
+			return size, nil
+		}
+
+		f, err := os.Open(archive)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+
+		// The gzip trailer holds the uncompressed size modulo 2^32.
+		trailer := make([]byte, 4)
+		if _, err := f.ReadAt(trailer, size-4); err != nil {
+			return 0, err
+		}
+		if isize := int64(binary.LittleEndian.Uint32(trailer)); isize > size {
+			return isize, nil
+		}
+	}
+
+	return size, nil
+}
+
+func checkFreeSpace(entry *ManifestEntry, archive, outputDir string) error {
+	needed, err := archiveExtractedSize(archive, entry.URI)
+	if err != nil {
+		return err
+	}
+
+	// Not knowing the free space is no reason to fail the install.
+	free, err := freeDiskSpace(outputDir)
+	if err != nil {
+		return nil
+	}
+
+	if needed > free {
+		return fmt.Errorf("not enough disk space to install %s %s: it needs about %s but only %s is free in %s", entry.Dependency.Name, entry.Dependency.Version, formatBytes(needed), formatBytes(free), outputDir)
+	}
+	return nil
+}
+
+func parseByteSize(size string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(size))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30} {
+		if strings.HasSuffix(value, suffix) {
+			multiplier = m
+			value = strings.TrimSuffix(value, suffix)
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size", size)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 3; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
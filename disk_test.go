@@ -0,0 +1,139 @@
+package libbuildpack_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Disk usage", func() {
+	var (
+		buildDir string
+		cacheDir string
+		depsDir  string
+		buffer   *bytes.Buffer
+		stager   *libbuildpack.Stager
+	)
+
+	writeFile := func(path string, size int) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(os.WriteFile(path, []byte(strings.Repeat("x", size)), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		tmpDir, err := os.MkdirTemp("", "disk")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, tmpDir)
+		buildDir = filepath.Join(tmpDir, "build")
+		cacheDir = filepath.Join(tmpDir, "cache")
+		depsDir = filepath.Join(tmpDir, "deps")
+
+		writeFile(filepath.Join(buildDir, "app.js"), 100)
+		writeFile(filepath.Join(buildDir, "node_modules", "a", "index.js"), 3000)
+		writeFile(filepath.Join(cacheDir, "node", "node.tgz"), 2000)
+		writeFile(filepath.Join(depsDir, "0", "node", "bin", "node"), 5000)
+		Expect(os.Symlink(filepath.Join(depsDir, "0", "node", "bin", "node"), filepath.Join(buildDir, "node"))).To(Succeed())
+
+		DeferCleanup(os.Setenv, libbuildpack.DiskLimitEnv, os.Getenv(libbuildpack.DiskLimitEnv))
+		os.Unsetenv(libbuildpack.DiskLimitEnv)
+
+		buffer = new(bytes.Buffer)
+		logger := libbuildpack.NewLogger(buffer)
+		manifest, err := libbuildpack.NewManifest(filepath.Join("fixtures", "manifest", "standard"), logger, time.Now())
+		Expect(err).To(BeNil())
+		stager = libbuildpack.NewStager([]string{buildDir, cacheDir, depsDir, "0"}, logger, manifest)
+	})
+
+	Describe("DirSize", func() {
+		It("sums regular files and skips symlinks", func() {
+			size, err := libbuildpack.DirSize(buildDir)
+			Expect(err).To(BeNil())
+			Expect(size).To(Equal(int64(3100)))
+		})
+
+		It("is 0 for a missing dir", func() {
+			size, err := libbuildpack.DirSize(filepath.Join(buildDir, "missing"))
+			Expect(err).To(BeNil())
+			Expect(size).To(Equal(int64(0)))
+		})
+	})
+
+	Describe("DiskUsage", func() {
+		It("measures the build, cache and dep dirs", func() {
+			usage, err := stager.DiskUsage()
+			Expect(err).To(BeNil())
+			Expect(usage).To(Equal(libbuildpack.DiskUsage{BuildDir: 3100, CacheDir: 2000, DepDir: 5000}))
+			Expect(usage.Total()).To(Equal(int64(10100)))
+		})
+	})
+
+	Describe("CheckDiskUsage", func() {
+		It("does not warn without a limit", func() {
+			_, err := stager.CheckDiskUsage()
+			Expect(err).To(BeNil())
+			Expect(buffer.String()).To(BeEmpty())
+		})
+
+		It("does not warn under the limit", func() {
+			os.Setenv(libbuildpack.DiskLimitEnv, "1M")
+			_, err := stager.CheckDiskUsage()
+			Expect(err).To(BeNil())
+			Expect(buffer.String()).To(BeEmpty())
+		})
+
+		It("warns with the largest directories over the limit", func() {
+			os.Setenv(libbuildpack.DiskLimitEnv, "8k")
+			_, err := stager.CheckDiskUsage()
+			Expect(err).To(BeNil())
+
+			Expect(buffer.String()).To(ContainSubstring("Staging uses 9.9 KB of disk, over the limit of 8.0 KB set in BP_DISK_LIMIT"))
+			lines := strings.Split(buffer.String(), "\n")
+			Expect(lines[2]).To(HaveSuffix(filepath.Join(depsDir, "0", "node")))
+			Expect(lines[3]).To(HaveSuffix(filepath.Join(buildDir, "node_modules")))
+			Expect(lines[4]).To(HaveSuffix(filepath.Join(cacheDir, "node")))
+		})
+
+		It("rejects an invalid limit", func() {
+			os.Setenv(libbuildpack.DiskLimitEnv, "lots")
+			_, err := stager.CheckDiskUsage()
+			Expect(err).To(MatchError(`invalid BP_DISK_LIMIT: "lots" is not a size`))
+		})
+	})
+
+	Describe("Step", func() {
+		It("records disk usage and warns only once", func() {
+			os.Setenv(libbuildpack.DiskLimitEnv, "8192")
+			Expect(stager.Step("one", func() error { return nil })).To(Succeed())
+			Expect(stager.Step("two", func() error { return nil })).To(Succeed())
+
+			Expect(strings.Count(buffer.String(), "**WARNING**")).To(Equal(1))
+			steps := stager.StagingReport().Steps
+			Expect(steps[1].DiskUsage).To(Equal(&libbuildpack.DiskUsage{BuildDir: 3100, CacheDir: 2000, DepDir: 5000}))
+		})
+	})
+
+	Describe("ArchiveExtractedSize", func() {
+		It("reads the uncompressed size of zip files and gzipped tarballs", func() {
+			size, err := libbuildpack.ArchiveExtractedSize(filepath.Join("fixtures", "thing.zip"))
+			Expect(err).To(BeNil())
+			Expect(size).To(Equal(int64(19)))
+
+			size, err = libbuildpack.ArchiveExtractedSize(filepath.Join("fixtures", "thing.tgz"))
+			Expect(err).To(BeNil())
+			Expect(size).To(Equal(int64(10240)))
+		})
+
+		It("uses the file size of other files", func() {
+			size, err := libbuildpack.ArchiveExtractedSize(filepath.Join("fixtures", "xzarchive.tar.xz"))
+			Expect(err).To(BeNil())
+			Expect(size).To(Equal(int64(312)))
+		})
+	})
+})
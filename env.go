@@ -0,0 +1,332 @@
+package libbuildpack
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvModifier selects how an env file written to <DepDir>/env combines with
+// the value a variable already has. Env files with a modifier are applied in
+// buildpack index order, at staging and at launch; plain env files keep
+// their staging-only behaviour.
+//
+// Env files in <DepDir>/build_env apply while staging only, and those in
+// <DepDir>/launch_env at launch only. In both, files without a modifier
+// override the variable.
+type EnvModifier string
+
+const (
+	EnvOverride EnvModifier = "override"
+	EnvDefault  EnvModifier = "default"
+	EnvPrepend  EnvModifier = "prepend"
+	EnvAppend   EnvModifier = "append"
+)
+
+const envDelimSuffix = ".delim"
+
+// EnvScope selects whether a variable written by WriteScopedEnvFile is set
+// while staging, when the app launches, or both.
+type EnvScope int
+
+const (
+	EnvScopeBuild EnvScope = iota
+	EnvScopeLaunch
+	EnvScopeBoth
+)
+
+// The dirs of the dep dir that hold env files, by where they apply.
+const (
+	envBothDir   = "env"
+	envBuildDir  = "build_env"
+	envLaunchDir = "launch_env"
+)
+
+// envFileDir is a dir of env files. In dirs with plainOverrides, files
+// without a modifier suffix are treated as overrides.
+type envFileDir struct {
+	name           string
+	plainOverrides bool
+}
+
+// The env file dirs read while staging and at launch, in the order they are
+// applied for each buildpack.
+var (
+	stagingEnvFileDirs = []envFileDir{{envBothDir, false}, {envBuildDir, true}}
+	launchEnvFileDirs  = []envFileDir{{envBothDir, false}, {envLaunchDir, true}}
+)
+
+var envVarNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var envModifierOrder = []EnvModifier{EnvOverride, EnvDefault, EnvPrepend, EnvAppend}
+
+type envModification struct {
+	name     string
+	modifier EnvModifier
+	value    string
+	delim    string
+}
+
+// WriteEnvFileWithModifier writes <DepDir>/env/<envVar>.<modifier>.
+func (s *Stager) WriteEnvFileWithModifier(envVar, envVal string, modifier EnvModifier) error {
+	if !validEnvModifier(modifier) {
+		return fmt.Errorf("invalid env modifier %s for %s", modifier, envVar)
+	}
+	if err := validateEnvFileName(envVar); err != nil {
+		return err
+	}
+
+	return s.WriteEnvFile(envVar+"."+string(modifier), envVal)
+}
+
+// WriteEnvFileDelimiter writes <DepDir>/env/<envVar>.delim, the separator
+// used when this buildpack prepends or appends to envVar.
+func (s *Stager) WriteEnvFileDelimiter(envVar, delim string) error {
+	if err := validateEnvFileName(envVar); err != nil {
+		return err
+	}
+	return s.WriteEnvFile(envVar+envDelimSuffix, delim)
+}
+
+// WriteScopedEnvFile sets envVar for the given scope. envVar may carry a
+// modifier or .delim suffix, e.g. JAVA_OPTS.append. Build scoped files are
+// written to <DepDir>/build_env, launch scoped ones to <DepDir>/launch_env,
+// and files scoped to both to <DepDir>/env, as an override if they have no
+// modifier.
+func (s *Stager) WriteScopedEnvFile(envVar, envVal string, scope EnvScope) error {
+	if err := validateEnvFileName(envVar); err != nil {
+		return err
+	}
+
+	switch scope {
+	case EnvScopeBuild:
+		return s.writeDepEnvFile(envBuildDir, envVar, envVal)
+	case EnvScopeLaunch:
+		return s.writeDepEnvFile(envLaunchDir, envVar, envVal)
+	case EnvScopeBoth:
+		if !isEnvModifierFile(envVar) {
+			envVar += "." + string(EnvOverride)
+		}
+		return s.writeDepEnvFile(envBothDir, envVar, envVal)
+	}
+	return fmt.Errorf("invalid env scope %d for %s", scope, envVar)
+}
+
+// writeDepEnvFile writes the env file fileName to subDir of the dep dir.
+func (s *Stager) writeDepEnvFile(subDir, fileName, envVal string) error {
+	envDir := filepath.Join(s.DepDir(), subDir)
+
+	if Planning() {
+		return s.manifest.recordPlanAction(PlanWriteEnvFile, filepath.Join(envDir, fileName), map[string]string{"value": envVal})
+	}
+
+	if err := os.MkdirAll(envDir, 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(envDir, fileName), []byte(envVal), 0644)
+}
+
+func validEnvModifier(modifier EnvModifier) bool {
+	for _, m := range envModifierOrder {
+		if m == modifier {
+			return true
+		}
+	}
+	return false
+}
+
+// validateEnvFileName refuses env file names whose variable, without the
+// modifier or delimiter suffix, the shell cannot export.
+func validateEnvFileName(fileName string) error {
+	name := fileName
+	if isEnvModifierFile(fileName) {
+		name = fileName[:strings.LastIndex(fileName, ".")]
+	}
+	if !envVarNameRe.MatchString(name) {
+		return fmt.Errorf("invalid environment variable name %s", name)
+	}
+	return nil
+}
+
+// isEnvModifierFile reports whether an env file name carries a modifier or
+// delimiter suffix, rather than naming a variable directly.
+func isEnvModifierFile(fileName string) bool {
+	if strings.HasSuffix(fileName, envDelimSuffix) {
+		return true
+	}
+	for _, m := range envModifierOrder {
+		if strings.HasSuffix(fileName, "."+string(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m envModification) apply(current string) string {
+	switch m.modifier {
+	case EnvDefault:
+		if current != "" {
+			return current
+		}
+	case EnvPrepend:
+		if current != "" {
+			return m.value + m.delim + current
+		}
+	case EnvAppend:
+		if current != "" {
+			return current + m.delim + m.value
+		}
+	}
+	return m.value
+}
+
+// envModifications reads the modifier env files in dirs of every dep dir,
+// ordered by buildpack index, then by the order of dirs. Within one dir,
+// overrides come first, then defaults, prepends and appends. Delimiters
+// apply to the modifiers of their own dir.
+// launchValueParts splits an env file value for the launch script, with the
+// deps dir of staging, where the value was written, replaced by a reference to
+// DEPS_DIR, which is where the deps are at launch.
+func launchValueParts(value, depsDir string) []profileValuePart {
+	var parts []profileValuePart
+	literal := ""
+	for depsDir != "" {
+		idx := strings.Index(value, depsDir)
+		if idx < 0 {
+			break
+		}
+		rest := value[idx+len(depsDir):]
+		if rest != "" && !os.IsPathSeparator(rest[0]) {
+			literal += value[:idx+len(depsDir)]
+			value = rest
+			continue
+		}
+
+		literal += value[:idx]
+		if literal != "" {
+			parts = append(parts, profileValuePart{text: literal})
+			literal = ""
+		}
+		parts = append(parts, profileValuePart{text: "DEPS_DIR", ref: true})
+		value = rest
+	}
+
+	literal += value
+	if literal != "" || len(parts) == 0 {
+		parts = append(parts, profileValuePart{text: literal})
+	}
+	return parts
+}
+
+func envModifications(depsDir string, dirs []envFileDir) ([]envModification, error) {
+	idxs, err := depsIdxs(depsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var mods []envModification
+	for _, idx := range idxs {
+		for _, dir := range dirs {
+			depMods, err := depEnvModifications(filepath.Join(depsDir, idx, dir.name), dir.plainOverrides)
+			if err != nil {
+				return nil, err
+			}
+			mods = append(mods, depMods...)
+		}
+	}
+
+	return mods, nil
+}
+
+func depEnvModifications(envDir string, plainOverrides bool) ([]envModification, error) {
+	files, err := os.ReadDir(envDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	values := map[string]string{}
+	for _, file := range files {
+		if !file.Type().IsRegular() {
+			continue
+		}
+
+		fileName := file.Name()
+		if !isEnvModifierFile(fileName) {
+			if !plainOverrides {
+				continue
+			}
+			fileName += "." + string(EnvOverride)
+		}
+
+		val, err := os.ReadFile(filepath.Join(envDir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		values[fileName] = string(val)
+	}
+
+	var mods []envModification
+	for _, modifier := range envModifierOrder {
+		var names []string
+		suffix := "." + string(modifier)
+		for fileName := range values {
+			if strings.HasSuffix(fileName, suffix) {
+				names = append(names, strings.TrimSuffix(fileName, suffix))
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if !envVarNameRe.MatchString(name) {
+				return nil, fmt.Errorf("invalid environment variable name %s in %s", name, envDir)
+			}
+			mods = append(mods, envModification{
+				name:     name,
+				modifier: modifier,
+				value:    values[name+suffix],
+				delim:    values[name+envDelimSuffix],
+			})
+		}
+	}
+
+	return mods, nil
+}
+
+// depsIdxs lists the dep dirs in depsDir, ordered by buildpack index.
+func depsIdxs(depsDir string) ([]string, error) {
+	files, err := os.ReadDir(depsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var idxs []string
+	for _, file := range files {
+		if file.IsDir() {
+			idxs = append(idxs, file.Name())
+		}
+	}
+	sortDepsIdxs(idxs)
+
+	return idxs, nil
+}
+
+// sortDepsIdxs orders deps dir names numerically, falling back to a string
+// comparison for names that are not numbers.
+func sortDepsIdxs(idxs []string) {
+	sort.SliceStable(idxs, func(i, j int) bool {
+		a, errA := strconv.Atoi(idxs[i])
+		b, errB := strconv.Atoi(idxs[j])
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return idxs[i] < idxs[j]
+	})
+}
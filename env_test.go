@@ -0,0 +1,289 @@
+package libbuildpack_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Env files", func() {
+	var (
+		buildDir   string
+		depsDir    string
+		profileDir string
+		s          *libbuildpack.Stager
+		err        error
+	)
+
+	writeEnv := func(idx, name, value string) {
+		Expect(os.MkdirAll(filepath.Join(depsDir, idx, "env"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(depsDir, idx, "env", name), []byte(value), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = os.MkdirTemp("", "build")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, buildDir)
+
+		depsDir, err = os.MkdirTemp("", "deps")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, depsDir)
+
+		profileDir, err = os.MkdirTemp("", "profiled")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, profileDir)
+
+		logger := libbuildpack.NewLogger(new(bytes.Buffer))
+		manifest, err := libbuildpack.NewManifest(filepath.Join("fixtures", "manifest", "standard"), logger, time.Now())
+		Expect(err).To(BeNil())
+
+		Expect(os.MkdirAll(filepath.Join(depsDir, "2"), 0755)).To(Succeed())
+		s = libbuildpack.NewStager([]string{buildDir, "", depsDir, "2", profileDir}, logger, manifest)
+
+		writeEnv("0", "JAVA_OPTS.append", "-Xmx1g")
+		writeEnv("0", "JAVA_OPTS.delim", " ")
+		writeEnv("10", "JAVA_OPTS.append", "-Dgreeting='hi there'")
+		writeEnv("10", "JAVA_OPTS.delim", " ")
+		writeEnv("0", "SEARCH_PATH.prepend", "/zero")
+		writeEnv("0", "SEARCH_PATH.delim", ":")
+		writeEnv("1", "SEARCH_PATH.prepend", "/one")
+		writeEnv("1", "SEARCH_PATH.delim", ":")
+		writeEnv("1", "UNSET_VAR.default", "fallback")
+		writeEnv("1", "SET_VAR.default", "fallback")
+		writeEnv("0", "WINNER.override", "first")
+		writeEnv("1", "WINNER.override", "$second")
+
+		for name, value := range map[string]string{
+			"JAVA_OPTS":   "-server",
+			"SEARCH_PATH": "/existing",
+			"UNSET_VAR":   "",
+			"SET_VAR":     "mine",
+			"WINNER":      "original",
+		} {
+			DeferCleanup(os.Setenv, name, os.Getenv(name))
+			os.Setenv(name, value)
+		}
+	})
+
+	Describe("WriteEnvFileWithModifier", func() {
+		It("writes a suffixed file in the <depDir>/env directory", func() {
+			Expect(s.WriteEnvFileWithModifier("JAVA_OPTS", "-Xss1m", libbuildpack.EnvAppend)).To(Succeed())
+			Expect(s.WriteEnvFileDelimiter("JAVA_OPTS", " ")).To(Succeed())
+
+			Expect(os.ReadFile(filepath.Join(s.DepDir(), "env", "JAVA_OPTS.append"))).To(Equal([]byte("-Xss1m")))
+			Expect(os.ReadFile(filepath.Join(s.DepDir(), "env", "JAVA_OPTS.delim"))).To(Equal([]byte(" ")))
+		})
+
+		It("rejects unknown modifiers", func() {
+			err := s.WriteEnvFileWithModifier("JAVA_OPTS", "-Xss1m", libbuildpack.EnvModifier("replace"))
+			Expect(err).To(MatchError("invalid env modifier replace for JAVA_OPTS"))
+		})
+	})
+
+	Describe("WriteScopedEnvFile", func() {
+		It("writes build scoped variables to <depDir>/build_env", func() {
+			Expect(s.WriteScopedEnvFile("BUILD_ONLY", "b", libbuildpack.EnvScopeBuild)).To(Succeed())
+
+			Expect(os.ReadFile(filepath.Join(s.DepDir(), "build_env", "BUILD_ONLY"))).To(Equal([]byte("b")))
+			Expect(filepath.Join(s.DepDir(), "env", "BUILD_ONLY")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(s.DepDir(), "launch_env", "BUILD_ONLY")).NotTo(BeAnExistingFile())
+		})
+
+		It("writes launch scoped variables to <depDir>/launch_env", func() {
+			Expect(s.WriteScopedEnvFile("LAUNCH_ONLY", "l", libbuildpack.EnvScopeLaunch)).To(Succeed())
+
+			Expect(os.ReadFile(filepath.Join(s.DepDir(), "launch_env", "LAUNCH_ONLY"))).To(Equal([]byte("l")))
+			Expect(filepath.Join(s.DepDir(), "env", "LAUNCH_ONLY")).NotTo(BeAnExistingFile())
+		})
+
+		It("writes variables scoped to both to <depDir>/env once, as overrides", func() {
+			Expect(s.WriteScopedEnvFile("EVERYWHERE", "e", libbuildpack.EnvScopeBoth)).To(Succeed())
+			Expect(s.WriteScopedEnvFile("JAVA_OPTS.append", "-Xss1m", libbuildpack.EnvScopeBoth)).To(Succeed())
+
+			Expect(os.ReadFile(filepath.Join(s.DepDir(), "env", "EVERYWHERE.override"))).To(Equal([]byte("e")))
+			Expect(os.ReadFile(filepath.Join(s.DepDir(), "env", "JAVA_OPTS.append"))).To(Equal([]byte("-Xss1m")))
+			Expect(filepath.Join(s.DepDir(), "launch_env")).NotTo(BeADirectory())
+			Expect(filepath.Join(s.DepDir(), "build_env")).NotTo(BeADirectory())
+		})
+	})
+
+	Describe("SetStagingEnvironment", func() {
+		BeforeEach(func() {
+			Expect(s.SetStagingEnvironment()).To(Succeed())
+		})
+
+		It("appends in buildpack index order", func() {
+			Expect(os.Getenv("JAVA_OPTS")).To(Equal("-server -Xmx1g -Dgreeting='hi there'"))
+		})
+
+		It("prepends in buildpack index order", func() {
+			Expect(os.Getenv("SEARCH_PATH")).To(Equal("/one:/zero:/existing"))
+		})
+
+		It("only sets defaults for empty variables", func() {
+			Expect(os.Getenv("UNSET_VAR")).To(Equal("fallback"))
+			Expect(os.Getenv("SET_VAR")).To(Equal("mine"))
+		})
+
+		It("lets the last override win", func() {
+			Expect(os.Getenv("WINNER")).To(Equal("$second"))
+		})
+
+		It("does not set variables named after modifier files", func() {
+			_, found := os.LookupEnv("JAVA_OPTS.append")
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	Describe("SetLaunchEnvironment", func() {
+		It("applies the same modifiers at launch", func() {
+			if runtime.GOOS == "windows" {
+				Skip("profile.d scripts are sourced by bash on Linux only")
+			}
+			Expect(s.SetLaunchEnvironment()).To(Succeed())
+
+			script := filepath.Join(profileDir, "000_multi-supply.sh")
+			cmd := exec.Command("bash", "-c", `source "$1" && printf '%s|%s|%s|%s|%s' "$JAVA_OPTS" "$SEARCH_PATH" "$UNSET_VAR" "$SET_VAR" "$WINNER"`, "--", script)
+			cmd.Env = []string{"DEPS_DIR=" + depsDir, "JAVA_OPTS=-server", "SEARCH_PATH=/existing", "SET_VAR=mine", "WINNER=original"}
+			output, err := cmd.Output()
+			Expect(err).To(BeNil())
+
+			Expect(string(output)).To(Equal("-server -Xmx1g -Dgreeting='hi there'|/one:/zero:/existing|fallback|mine|$second"))
+		})
+
+		It("refers to paths in the deps dir through DEPS_DIR", func() {
+			if runtime.GOOS == "windows" {
+				Skip("profile.d scripts are sourced by bash on Linux only")
+			}
+			Expect(s.WriteScopedEnvFile("JAVA_HOME", filepath.Join(depsDir, "3", "jdk"), libbuildpack.EnvScopeLaunch)).To(Succeed())
+			Expect(s.WriteEnvFileWithModifier("TOOL_PATH", filepath.Join(depsDir, "3", "bin")+":"+depsDir+"-other", libbuildpack.EnvPrepend)).To(Succeed())
+			Expect(s.WriteEnvFileDelimiter("TOOL_PATH", ":")).To(Succeed())
+			Expect(s.SetLaunchEnvironment()).To(Succeed())
+
+			script := filepath.Join(profileDir, "000_multi-supply.sh")
+			cmd := exec.Command("bash", "-c", `source "$1" && printf '%s|%s' "$JAVA_HOME" "$TOOL_PATH"`, "--", script)
+			cmd.Env = []string{"DEPS_DIR=/home/vcap/deps", "TOOL_PATH=/usr/bin"}
+			output, err := cmd.Output()
+			Expect(err).To(BeNil())
+
+			Expect(string(output)).To(Equal("/home/vcap/deps/3/jdk|/home/vcap/deps/3/bin:" + depsDir + "-other:/usr/bin"))
+		})
+
+		Context("buildpacks write launch scoped variables", func() {
+			BeforeEach(func() {
+				Expect(s.WriteScopedEnvFile("GREETING", `it's "$HOME" \ $(date)`, libbuildpack.EnvScopeLaunch)).To(Succeed())
+				Expect(s.WriteScopedEnvFile("SHARED", "both", libbuildpack.EnvScopeBoth)).To(Succeed())
+				Expect(s.WriteScopedEnvFile("STAGING", "build", libbuildpack.EnvScopeBuild)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(depsDir, "3", "launch_env"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(depsDir, "3", "launch_env", "SHARED.append"), []byte("launch"), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(depsDir, "3", "launch_env", "SHARED.delim"), []byte(","), 0644)).To(Succeed())
+			})
+
+			It("exports them verbatim at launch only", func() {
+				if runtime.GOOS == "windows" {
+					Skip("profile.d scripts are sourced by bash on Linux only")
+				}
+				Expect(s.SetLaunchEnvironment()).To(Succeed())
+
+				script := filepath.Join(profileDir, "000_multi-supply.sh")
+				cmd := exec.Command("bash", "-c", `source "$1" && printf '%s|%s|%s' "$GREETING" "$SHARED" "${STAGING:-unset}"`, "--", script)
+				cmd.Env = []string{"DEPS_DIR=" + depsDir, "HOME=/home/vcap"}
+				output, err := cmd.Output()
+				Expect(err).To(BeNil())
+
+				Expect(string(output)).To(Equal(`it's "$HOME" \ $(date)|both,launch|unset`))
+			})
+
+			It("does not set launch only variables while staging", func() {
+				DeferCleanup(os.Unsetenv, "GREETING")
+				DeferCleanup(os.Unsetenv, "SHARED")
+				DeferCleanup(os.Unsetenv, "STAGING")
+				Expect(s.SetStagingEnvironment()).To(Succeed())
+
+				_, found := os.LookupEnv("GREETING")
+				Expect(found).To(BeFalse())
+				Expect(os.Getenv("SHARED")).To(Equal("both"))
+				Expect(os.Getenv("STAGING")).To(Equal("build"))
+			})
+		})
+
+		Context("buildpacks write scoped modifiers", func() {
+			BeforeEach(func() {
+				for _, idx := range []string{"0", "1", "10"} {
+					Expect(os.RemoveAll(filepath.Join(depsDir, idx))).To(Succeed())
+				}
+				zero := libbuildpack.NewStager([]string{buildDir, "", depsDir, "0", profileDir}, libbuildpack.NewLogger(new(bytes.Buffer)), nil)
+				one := libbuildpack.NewStager([]string{buildDir, "", depsDir, "1", profileDir}, libbuildpack.NewLogger(new(bytes.Buffer)), nil)
+
+				Expect(one.WriteScopedEnvFile("JAVA_OPTS.append", "-Dbuild-only", libbuildpack.EnvScopeBuild)).To(Succeed())
+				Expect(zero.WriteScopedEnvFile("JAVA_OPTS.append", "-Dzero", libbuildpack.EnvScopeBoth)).To(Succeed())
+				Expect(zero.WriteScopedEnvFile("JAVA_OPTS.delim", " ", libbuildpack.EnvScopeBoth)).To(Succeed())
+				Expect(one.WriteScopedEnvFile("JAVA_OPTS.append", "-Done", libbuildpack.EnvScopeLaunch)).To(Succeed())
+				Expect(one.WriteScopedEnvFile("JAVA_OPTS.delim", ",", libbuildpack.EnvScopeLaunch)).To(Succeed())
+				Expect(s.WriteScopedEnvFile("JAVA_OPTS.append", "-Dtwo", libbuildpack.EnvScopeBoth)).To(Succeed())
+				Expect(s.WriteScopedEnvFile("JAVA_OPTS.delim", ";", libbuildpack.EnvScopeBoth)).To(Succeed())
+			})
+
+			It("applies launch and shared modifiers once each, in buildpack index order", func() {
+				if runtime.GOOS == "windows" {
+					Skip("profile.d scripts are sourced by bash on Linux only")
+				}
+				Expect(s.SetLaunchEnvironment()).To(Succeed())
+
+				contents, err := os.ReadFile(filepath.Join(profileDir, "000_multi-supply.sh"))
+				Expect(err).To(BeNil())
+				Expect(string(contents)).NotTo(ContainSubstring("-Dbuild-only"))
+				Expect(string(contents)).To(ContainSubstring(`if [[ -n "${JAVA_OPTS:-}" ]]; then export JAVA_OPTS="$JAVA_OPTS"' ''-Dzero'; else export JAVA_OPTS='-Dzero'; fi` + "\n" +
+					`if [[ -n "${JAVA_OPTS:-}" ]]; then export JAVA_OPTS="$JAVA_OPTS"',''-Done'; else export JAVA_OPTS='-Done'; fi` + "\n" +
+					`if [[ -n "${JAVA_OPTS:-}" ]]; then export JAVA_OPTS="$JAVA_OPTS"';''-Dtwo'; else export JAVA_OPTS='-Dtwo'; fi` + "\n"))
+
+				cmd := exec.Command("bash", "-c", `source "$1" && printf '%s' "$JAVA_OPTS"`, "--", filepath.Join(profileDir, "000_multi-supply.sh"))
+				cmd.Env = []string{"DEPS_DIR=" + depsDir, "JAVA_OPTS=-server"}
+				output, err := cmd.Output()
+				Expect(err).To(BeNil())
+				Expect(string(output)).To(Equal("-server -Dzero,-Done;-Dtwo"))
+			})
+
+			It("applies build and shared modifiers while staging", func() {
+				Expect(s.SetStagingEnvironment()).To(Succeed())
+				Expect(os.Getenv("JAVA_OPTS")).To(Equal("-server -Dzero-Dbuild-only;-Dtwo"))
+			})
+		})
+
+		It("rejects variable names the shell cannot export when they are written", func() {
+			Expect(s.WriteScopedEnvFile("NOT-VALID", "x", libbuildpack.EnvScopeLaunch)).To(MatchError("invalid environment variable name NOT-VALID"))
+			Expect(s.WriteScopedEnvFile("NOT VALID.append", "x", libbuildpack.EnvScopeBoth)).To(MatchError("invalid environment variable name NOT VALID"))
+			Expect(s.WriteEnvFileWithModifier("1NVALID", "x", libbuildpack.EnvPrepend)).To(MatchError("invalid environment variable name 1NVALID"))
+			Expect(s.WriteEnvFileDelimiter("NOT-VALID", ":")).To(MatchError("invalid environment variable name NOT-VALID"))
+			Expect(filepath.Join(s.DepDir(), "launch_env")).NotTo(BeADirectory())
+		})
+
+		It("rejects variable names the shell cannot export when they are read", func() {
+			Expect(os.MkdirAll(filepath.Join(s.DepDir(), "launch_env"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(s.DepDir(), "launch_env", "NOT-VALID"), []byte("x"), 0644)).To(Succeed())
+			Expect(s.SetLaunchEnvironment()).To(MatchError(ContainSubstring("invalid environment variable name NOT-VALID")))
+		})
+
+		It("escapes values for cmd on Windows", func() {
+			if runtime.GOOS != "windows" {
+				Skip("renders batch files on Windows only")
+			}
+			Expect(s.WriteScopedEnvFile("GREETING", `100% "done" & more`, libbuildpack.EnvScopeLaunch)).To(Succeed())
+			Expect(s.WriteScopedEnvFile("RATE", "50%", libbuildpack.EnvScopeLaunch)).To(Succeed())
+			Expect(s.SetLaunchEnvironment()).To(Succeed())
+
+			contents, err := os.ReadFile(filepath.Join(profileDir, "000_multi-supply.bat"))
+			Expect(err).To(BeNil())
+			Expect(string(contents)).To(ContainSubstring(`set GREETING=100%% ^"done^" ^& more`))
+			Expect(string(contents)).To(ContainSubstring(`set "RATE=50%%"`))
+		})
+	})
+})
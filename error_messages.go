@@ -1,6 +1,9 @@
 package libbuildpack
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 const defaultVersionsError = "The buildpack manifest is misconfigured for 'default_versions'. " +
 	"Contact your Cloud Foundry operator/admin. For more information, see " +
@@ -26,6 +29,50 @@ func dependencyMissingError(m *Manifest, dep Dependency) string {
 	return msg
 }
 
+func modulesMissingError(dep Dependency, unknown []string, available []SubDependency) string {
+	var msg string
+
+	msg += fmt.Sprintf("MODULES MISSING IN MANIFEST:\n\n")
+
+	if len(available) == 0 {
+		msg += fmt.Sprintf("Dependency %s %s does not provide any modules in this buildpack\n", dep.Name, dep.Version)
+		return msg
+	}
+
+	msg += fmt.Sprintf("The following modules of %s %s are not supported by this buildpack:\n", dep.Name, dep.Version)
+	for _, name := range unknown {
+		if suggestion := similarModule(name, available); suggestion != "" {
+			msg += fmt.Sprintf("\t- %s (did you mean %s?)\n", name, suggestion)
+		} else {
+			msg += fmt.Sprintf("\t- %s\n", name)
+		}
+	}
+
+	msg += fmt.Sprintf("The modules of %s %s supported in this buildpack are:\n", dep.Name, dep.Version)
+	for _, module := range available {
+		if module.Version != "" {
+			msg += fmt.Sprintf("\t- %s %s\n", module.Name, module.Version)
+		} else {
+			msg += fmt.Sprintf("\t- %s\n", module.Name)
+		}
+	}
+
+	return msg
+}
+
+func similarModule(name string, available []SubDependency) string {
+	normalize := func(s string) string {
+		return strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(s))
+	}
+
+	for _, module := range available {
+		if normalize(module.Name) == normalize(name) {
+			return module.Name
+		}
+	}
+	return ""
+}
+
 func outdatedDependencyWarning(dep Dependency, newest string) string {
 	warning := "A newer version of %s is available in this buildpack. " +
 		"Please adjust your app to use version %s instead of version %s as soon as possible. " +
@@ -34,6 +81,13 @@ func outdatedDependencyWarning(dep Dependency, newest string) string {
 	return fmt.Sprintf(warning, dep.Name, newest, dep.Version, dep.Name)
 }
 
+func substitutedVersionWarning(dep Dependency, wanted, constraint string) string {
+	warning := "Installing %s %s instead of %s because %s could not be fetched or verified. " +
+		"%s is the highest remaining version matching %s."
+
+	return fmt.Sprintf(warning, dep.Name, dep.Version, wanted, wanted, dep.Version, constraint)
+}
+
 func endOfLifeWarning(depName, versionLine, eolDate, link string) string {
 	warning := "%s %s will no longer be available in new buildpacks released after %s."
 	if link != "" {
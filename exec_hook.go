@@ -0,0 +1,242 @@
+package libbuildpack
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Env vars that control executable hooks. ExecHooksEnv turns them on in
+// SupplyMain and FinalizeMain, and HookTimeoutEnv limits how long each hook
+// may run at a lifecycle point, e.g. 2m. The hook runners give every hook,
+// not just executable ones, that budget; for an ExecHook it covers all
+// executables of the point.
+const (
+	ExecHooksEnv   = "BP_EXEC_HOOKS"
+	HookTimeoutEnv = "BP_HOOK_TIMEOUT"
+)
+
+// AppHooksFile is the file in <buildpack>/hooks whose presence allows hooks
+// from the app. It is part of the buildpack, so only its authors or the
+// operator who installs it can allow them, not app developers.
+const AppHooksFile = "allow-app-hooks"
+
+// ExecHook runs the executables in <buildpack>/hooks/<point>.d and, if
+// AllowAppHooks is set, <app>/.buildpack-hooks/<point>.d, in name order, at
+// each lifecycle point. The points are before-compile, after-compile,
+// before-supply, after-supply, before-finalize, after-finalize and
+// on-failure.
+//
+// Hooks run in the build dir with BUILD_DIR, CACHE_DIR, DEPS_DIR, DEPS_IDX
+// and BP_HOOK_POINT added to the environment, and BP_FAILURE at on-failure.
+// Their output is logged line by line. A hook that exits non-zero fails the
+// lifecycle point, as do hooks still running when Timeout, if set, is up.
+// In plan mode the hooks are recorded instead of run.
+type ExecHook struct {
+	DefaultHook
+	BuildpackDir  string
+	AllowAppHooks bool
+	Timeout       time.Duration
+
+	ctx context.Context
+}
+
+// NewExecHook returns an ExecHook for the hooks of buildpackDir, configured
+// from AppHooksFile and HookTimeoutEnv.
+func NewExecHook(buildpackDir string) (*ExecHook, error) {
+	timeout, err := OperationTimeout(HookTimeoutEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	allowAppHooks, err := FileExists(filepath.Join(buildpackDir, "hooks", AppHooksFile))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExecHook{
+		BuildpackDir:  buildpackDir,
+		AllowAppHooks: allowAppHooks,
+		Timeout:       timeout,
+	}, nil
+}
+
+// ExecHooksEnabled reports whether ExecHooksEnv turns on executable hooks.
+func ExecHooksEnabled() bool {
+	return os.Getenv(ExecHooksEnv) == "true"
+}
+
+func (h *ExecHook) Name() string { return "exec hooks" }
+
+// WithContext returns a copy of h whose hooks are killed when ctx is done.
+func (h *ExecHook) WithContext(ctx context.Context) Hook {
+	hook := *h
+	hook.ctx = ctx
+	return &hook
+}
+
+func (h *ExecHook) BeforeCompile(stager *Stager) error  { return h.Run(stager, "before-compile", nil) }
+func (h *ExecHook) AfterCompile(stager *Stager) error   { return h.Run(stager, "after-compile", nil) }
+func (h *ExecHook) BeforeSupply(stager *Stager) error   { return h.Run(stager, "before-supply", nil) }
+func (h *ExecHook) AfterSupply(stager *Stager) error    { return h.Run(stager, "after-supply", nil) }
+func (h *ExecHook) BeforeFinalize(stager *Stager) error { return h.Run(stager, "before-finalize", nil) }
+func (h *ExecHook) AfterFinalize(stager *Stager) error  { return h.Run(stager, "after-finalize", nil) }
+
+func (h *ExecHook) OnFailure(stager *Stager, failure error) error {
+	return h.Run(stager, "on-failure", []string{"BP_FAILURE=" + failure.Error()})
+}
+
+// Run runs the hooks of point with env added to their environment.
+func (h *ExecHook) Run(stager *Stager, point string, env []string) error {
+	scripts, err := h.Scripts(stager, point)
+	if err != nil {
+		return err
+	}
+
+	ctx := h.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timeout := h.Timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline).Round(time.Millisecond); timeout == 0 || remaining < timeout {
+			timeout = remaining
+		}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	env = append(append(os.Environ(),
+		"BUILD_DIR="+stager.BuildDir(),
+		"CACHE_DIR="+stager.CacheDir(),
+		"DEPS_DIR="+stager.DepsDir(),
+		"DEPS_IDX="+stager.DepsIdx(),
+		"BP_HOOK_POINT="+point,
+	), env...)
+
+	for _, script := range scripts {
+		if Planning() {
+			if err := stager.manifest.recordPlanAction(PlanRunHook, script, map[string]string{"point": point}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := h.runScript(ctx, stager, script, env, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scripts returns the executables that run at point, buildpack hooks first.
+func (h *ExecHook) Scripts(stager *Stager, point string) ([]string, error) {
+	dirs := []string{filepath.Join(h.BuildpackDir, "hooks", point+".d")}
+	if h.AllowAppHooks {
+		dirs = append(dirs, filepath.Join(stager.BuildDir(), ".buildpack-hooks", point+".d"))
+	}
+
+	var scripts []string
+	for _, dir := range dirs {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		var names []string
+		for _, file := range files {
+			info, err := file.Info()
+			if err != nil {
+				return nil, err
+			}
+			if !info.Mode().IsRegular() {
+				continue
+			}
+			if !isExecutableHook(info) {
+				stager.log.Warning("Skipping hook %s: it is not executable", filepath.Join(dir, file.Name()))
+				continue
+			}
+			names = append(names, file.Name())
+		}
+
+		sort.Strings(names)
+		for _, name := range names {
+			scripts = append(scripts, filepath.Join(dir, name))
+		}
+	}
+	return scripts, nil
+}
+
+// runScript runs script, which is stopped when ctx is done. timeout is the
+// time ctx had when the hooks of the point started, for the error.
+func (h *ExecHook) runScript(ctx context.Context, stager *Stager, script string, env []string, timeout time.Duration) error {
+	name := filepath.Base(script)
+	stager.log.BeginStep("Running hook %s", name)
+
+	output := newLineLogger(stager.log)
+	defer output.Close()
+
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Dir = stager.BuildDir()
+	cmd.Env = env
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if ctxErr := ctx.Err(); ctxErr == context.DeadlineExceeded {
+		return fmt.Errorf("hook %s timed out after %s: %w", script, timeout, ctxErr)
+	} else if ctxErr != nil {
+		return fmt.Errorf("hook %s stopped: %w", script, ctxErr)
+	}
+	if err != nil {
+		return fmt.Errorf("hook %s failed: %v", script, err)
+	}
+	return nil
+}
+
+// lineLogger logs everything written to it with Logger.Info, line by line.
+type lineLogger struct {
+	lock    sync.Mutex
+	log     *Logger
+	partial string
+}
+
+func newLineLogger(log *Logger) *lineLogger {
+	return &lineLogger{log: log}
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	lines := strings.Split(l.partial+string(p), "\n")
+	l.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		l.log.Info("%s", strings.TrimSuffix(line, "\r"))
+	}
+	return len(p), nil
+}
+
+// Close logs a trailing line without a newline.
+func (l *lineLogger) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.partial != "" {
+		l.log.Info("%s", l.partial)
+		l.partial = ""
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package libbuildpack_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ExecHook", func() {
+	var (
+		buildpackDir string
+		buildDir     string
+		depsDir      string
+		buffer       *bytes.Buffer
+		stager       *libbuildpack.Stager
+		hook         *libbuildpack.ExecHook
+	)
+
+	writeHook := func(dir, name, script string, mode os.FileMode) {
+		Expect(os.MkdirAll(dir, 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), mode)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		if runtime.GOOS == "windows" {
+			Skip("hook scripts are shell scripts")
+		}
+
+		tmpDir, err := os.MkdirTemp("", "exec-hook")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, tmpDir)
+		buildpackDir = filepath.Join(tmpDir, "buildpack")
+		buildDir = filepath.Join(tmpDir, "build")
+		depsDir = filepath.Join(tmpDir, "deps")
+		Expect(os.MkdirAll(buildDir, 0755)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(depsDir, "3"), 0755)).To(Succeed())
+
+		DeferCleanup(os.Setenv, libbuildpack.HookTimeoutEnv, os.Getenv(libbuildpack.HookTimeoutEnv))
+		os.Unsetenv(libbuildpack.HookTimeoutEnv)
+
+		buffer = new(bytes.Buffer)
+		stager = libbuildpack.NewStager([]string{buildDir, "/cache", depsDir, "3"}, libbuildpack.NewLogger(buffer), nil)
+		hook, err = libbuildpack.NewExecHook(buildpackDir)
+		Expect(err).To(BeNil())
+	})
+
+	It("runs buildpack hooks in name order with the staging env", func() {
+		dir := filepath.Join(buildpackDir, "hooks", "before-supply.d")
+		writeHook(dir, "20-second", "echo second\n", 0755)
+		writeHook(dir, "10-first", `echo "$BUILD_DIR $CACHE_DIR $DEPS_DIR $DEPS_IDX $BP_HOOK_POINT $(pwd)"`+"\n", 0755)
+
+		Expect(hook.BeforeSupply(stager)).To(Succeed())
+		Expect(buffer.String()).To(Equal(
+			"-----> Running hook 10-first\n" +
+				"       " + buildDir + " /cache " + depsDir + " 3 before-supply " + buildDir + "\n" +
+				"-----> Running hook 20-second\n" +
+				"       second\n"))
+	})
+
+	It("skips files that are not executable", func() {
+		writeHook(filepath.Join(buildpackDir, "hooks", "after-finalize.d"), "README", "echo ran\n", 0644)
+
+		Expect(hook.AfterFinalize(stager)).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Skipping hook"))
+		Expect(buffer.String()).NotTo(ContainSubstring("ran"))
+	})
+
+	It("does nothing without hooks", func() {
+		Expect(hook.BeforeCompile(stager)).To(Succeed())
+		Expect(hook.AfterCompile(stager)).To(Succeed())
+		Expect(buffer.String()).To(BeEmpty())
+	})
+
+	It("runs app hooks only when allowed", func() {
+		writeHook(filepath.Join(buildDir, ".buildpack-hooks", "before-finalize.d"), "app", "echo from app\n", 0755)
+
+		Expect(hook.BeforeFinalize(stager)).To(Succeed())
+		Expect(buffer.String()).To(BeEmpty())
+
+		os.Setenv("BP_ALLOW_APP_HOOKS", "true")
+		DeferCleanup(os.Unsetenv, "BP_ALLOW_APP_HOOKS")
+		hook, err := libbuildpack.NewExecHook(buildpackDir)
+		Expect(err).To(BeNil())
+		Expect(hook.BeforeFinalize(stager)).To(Succeed())
+		Expect(buffer.String()).To(BeEmpty())
+
+		Expect(os.MkdirAll(filepath.Join(buildpackDir, "hooks"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(buildpackDir, "hooks", libbuildpack.AppHooksFile), nil, 0644)).To(Succeed())
+		hook, err = libbuildpack.NewExecHook(buildpackDir)
+		Expect(err).To(BeNil())
+		Expect(hook.BeforeFinalize(stager)).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("from app"))
+	})
+
+	It("fails when a hook fails", func() {
+		writeHook(filepath.Join(buildpackDir, "hooks", "after-supply.d"), "scan", "echo vulnerable\nexit 3\n", 0755)
+
+		err := hook.AfterSupply(stager)
+		Expect(err).To(MatchError(ContainSubstring("scan failed: exit status 3")))
+		Expect(buffer.String()).To(ContainSubstring("vulnerable"))
+	})
+
+	It("passes the failure to on-failure hooks", func() {
+		writeHook(filepath.Join(buildpackDir, "hooks", "on-failure.d"), "report", `printf "%s" "$BP_FAILURE"`, 0755)
+
+		Expect(hook.OnFailure(stager, errors.New("no node"))).To(Succeed())
+		Expect(buffer.String()).To(HaveSuffix("       no node\n"))
+	})
+
+	It("times out hooks", func() {
+		os.Setenv(libbuildpack.HookTimeoutEnv, "100ms")
+		hook, err := libbuildpack.NewExecHook(buildpackDir)
+		Expect(err).To(BeNil())
+		writeHook(filepath.Join(buildpackDir, "hooks", "before-compile.d"), "slow", "sleep 10\n", 0755)
+
+		start := time.Now()
+		Expect(hook.BeforeCompile(stager)).To(MatchError(ContainSubstring("slow timed out after 100ms")))
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+	})
+
+	It("reports the deadline of its context when that is sooner", func() {
+		writeHook(filepath.Join(buildpackDir, "hooks", "before-compile.d"), "slow", "sleep 10\n", 0755)
+		hook.Timeout = time.Minute
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		err := hook.WithContext(ctx).BeforeCompile(stager)
+		Expect(err).To(MatchError(ContainSubstring("slow timed out after 100ms")))
+		Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+	})
+
+	It("rejects an invalid timeout", func() {
+		os.Setenv(libbuildpack.HookTimeoutEnv, "soon")
+		_, err := libbuildpack.NewExecHook(buildpackDir)
+		Expect(err).To(MatchError(`invalid BP_HOOK_TIMEOUT: "soon" is not a duration`))
+
+		os.Setenv(libbuildpack.HookTimeoutEnv, "-1m")
+		_, err = libbuildpack.NewExecHook(buildpackDir)
+		Expect(err).To(MatchError(`invalid BP_HOOK_TIMEOUT: "-1m" is not a duration`))
+	})
+
+	It("has no timeout unless BP_HOOK_TIMEOUT sets one", func() {
+		Expect(hook.Timeout).To(BeZero())
+	})
+})
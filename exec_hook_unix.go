@@ -0,0 +1,9 @@
+// +build !windows
+
+package libbuildpack
+
+import "os"
+
+func isExecutableHook(info os.FileInfo) bool {
+	return info.Mode().Perm()&0111 != 0
+}
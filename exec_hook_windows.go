@@ -0,0 +1,17 @@
+// +build windows
+
+package libbuildpack
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func isExecutableHook(info os.FileInfo) bool {
+	switch strings.ToLower(filepath.Ext(info.Name())) {
+	case ".bat", ".cmd", ".exe":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package libbuildpack
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const installedDependenciesFile = "installed_dependencies.yml"
+
+// DependencyFingerprint identifies the exact build of a dependency that was
+// installed. The Installer records one for every dependency it installs, and
+// StoreBuildpackMetadata persists them in BUILDPACK_METADATA.
+//
+// Supply and finalize run as separate processes, so Stager.StagingComplete
+// also keeps them in <DepDir>/installed_dependencies.yml for the next phase.
+type DependencyFingerprint struct {
+	Name    string `yaml:"name" json:"name"`
+	Version string `yaml:"version" json:"version"`
+	SHA256  string `yaml:"sha256" json:"sha256"`
+}
+
+// DependencyFingerprint returns the fingerprint dep would be installed with on
+// the current stack.
+func (m *Manifest) DependencyFingerprint(dep Dependency) (DependencyFingerprint, error) {
+	entry, err := m.GetEntry(dep)
+	if err != nil {
+		return DependencyFingerprint{}, err
+	}
+	return fingerprintOf(entry), nil
+}
+
+// InstalledDependencies returns the fingerprints of the dependencies
+// installed by this process, and by the earlier phases once
+// Stager.StagingComplete has run.
+func (m *Manifest) InstalledDependencies() []DependencyFingerprint {
+	return m.installed
+}
+
+// RecordDependency records dep as used by this staging, as if it had been
+// installed.
+func (m *Manifest) RecordDependency(dep Dependency) error {
+	entry, err := m.GetEntry(dep)
+	if err != nil {
+		return err
+	}
+	m.recordInstalled(entry)
+	return nil
+}
+
+// PreviousBuildpackMetadata returns what StoreBuildpackMetadata stored in
+// cacheDir during the previous staging. found is false if there was none, or
+// if it was written by another buildpack.
+func (m *Manifest) PreviousBuildpackMetadata(cacheDir string) (md BuildpackMetadata, found bool, err error) {
+	if err := NewYAML().Load(filepath.Join(cacheDir, "BUILDPACK_METADATA"), &md); err != nil {
+		if os.IsNotExist(err) {
+			return BuildpackMetadata{}, false, nil
+		}
+		return BuildpackMetadata{}, false, err
+	}
+
+	if md.Language != m.Language() {
+		return BuildpackMetadata{}, false, nil
+	}
+	return md, true, nil
+}
+
+// ChangedDependencies returns the names of the deps whose fingerprint differs
+// from the previous staging in cacheDir. Every dep has changed if there was no
+// previous staging or if the stack changed.
+func (m *Manifest) ChangedDependencies(cacheDir string, deps ...Dependency) ([]string, error) {
+	previous, found, err := m.PreviousBuildpackMetadata(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make([]DependencyFingerprint, 0, len(deps))
+	for _, dep := range deps {
+		fingerprint, err := m.DependencyFingerprint(dep)
+		if err != nil {
+			return nil, err
+		}
+		current = append(current, fingerprint)
+	}
+
+	if !found || previous.Stack != os.Getenv("CF_STACK") {
+		names := make([]string, 0, len(current))
+		for _, fingerprint := range current {
+			names = append(names, fingerprint.Name)
+		}
+		return names, nil
+	}
+
+	return previous.ChangedDependencies(current), nil
+}
+
+// ChangedDependencies returns the names of the current fingerprints that
+// were not recorded in md.
+func (md BuildpackMetadata) ChangedDependencies(current []DependencyFingerprint) []string {
+	var changed []string
+	for _, fingerprint := range current {
+		if !md.HasDependency(fingerprint) {
+			changed = append(changed, fingerprint.Name)
+		}
+	}
+	return changed
+}
+
+func (md BuildpackMetadata) HasDependency(fingerprint DependencyFingerprint) bool {
+	return hasFingerprint(md.Dependencies, fingerprint)
+}
+
+// syncInstalledDependencies adds the fingerprints recorded in <DepDir> by an
+// earlier phase to those of this process, and records them all there.
+func (s *Stager) syncInstalledDependencies() error {
+	file := filepath.Join(s.DepDir(), installedDependenciesFile)
+
+	var earlier []DependencyFingerprint
+	if err := NewYAML().Load(file, &earlier); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	installed := s.manifest.installed
+	s.manifest.installed = nil
+	for _, fingerprint := range append(earlier, installed...) {
+		s.manifest.addInstalled(fingerprint)
+	}
+
+	if Planning() {
+		return s.manifest.planWriteYAML(file, s.manifest.installed)
+	}
+	return NewYAML().Write(file, s.manifest.installed)
+}
+
+func (m *Manifest) recordInstalled(entry *ManifestEntry) {
+	m.addInstalled(fingerprintOf(entry))
+}
+
+func (m *Manifest) addInstalled(fingerprint DependencyFingerprint) {
+	if !hasFingerprint(m.installed, fingerprint) {
+		m.installed = append(m.installed, fingerprint)
+	}
+}
+
+func hasFingerprint(fingerprints []DependencyFingerprint, fingerprint DependencyFingerprint) bool {
+	for _, f := range fingerprints {
+		if f == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+func fingerprintOf(entry *ManifestEntry) DependencyFingerprint {
+	return DependencyFingerprint{Name: entry.Dependency.Name, Version: entry.Dependency.Version, SHA256: entry.SHA256}
+}
@@ -0,0 +1,160 @@
+package libbuildpack_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	httpmock "github.com/jarcoal/httpmock"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dependency fingerprints", func() {
+	var (
+		manifest *libbuildpack.Manifest
+		cacheDir string
+		realTar  libbuildpack.Dependency
+		thing    libbuildpack.Dependency
+		err      error
+	)
+
+	BeforeEach(func() {
+		DeferCleanup(os.Setenv, "CF_STACK", os.Getenv("CF_STACK"))
+		os.Setenv("CF_STACK", "cflinuxfs2")
+		httpmock.Reset()
+
+		cacheDir, err = os.MkdirTemp("", "cache")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, cacheDir)
+
+		manifestDir, err := os.MkdirTemp("", "manifest")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, manifestDir)
+		Expect(libbuildpack.CopyDirectory(filepath.Join("fixtures", "manifest", "fetch"), manifestDir)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(manifestDir, "VERSION"), []byte("1.0.0"), 0644)).To(Succeed())
+
+		manifest, err = libbuildpack.NewManifest(manifestDir, libbuildpack.NewLogger(new(bytes.Buffer)), time.Now())
+		Expect(err).To(BeNil())
+
+		realTar = libbuildpack.Dependency{Name: "real_tar_file", Version: "3"}
+		thing = libbuildpack.Dependency{Name: "thing", Version: "1"}
+	})
+
+	writeMetadata := func(contents string) {
+		Expect(os.WriteFile(filepath.Join(cacheDir, "BUILDPACK_METADATA"), []byte(contents), 0644)).To(Succeed())
+	}
+
+	It("returns the fingerprint of a manifest entry", func() {
+		Expect(manifest.DependencyFingerprint(realTar)).To(Equal(libbuildpack.DependencyFingerprint{
+			Name:    "real_tar_file",
+			Version: "3",
+			SHA256:  "8208480eb849203632239f73bd3c61ed488546d19d29c06d7c2e1649d8950bd1",
+		}))
+	})
+
+	Context("a dependency is installed", func() {
+		BeforeEach(func() {
+			tgzContents, err := os.ReadFile("fixtures/thing.tgz")
+			Expect(err).To(BeNil())
+			httpmock.RegisterResponder("GET", "https://example.com/dependencies/real_tar_file-3-linux-x64.tgz",
+				httpmock.NewStringResponder(200, string(tgzContents)))
+
+			outputDir, err := os.MkdirTemp("", "downloads")
+			Expect(err).To(BeNil())
+			DeferCleanup(os.RemoveAll, outputDir)
+
+			installer := libbuildpack.NewInstaller(manifest)
+			Expect(installer.InstallDependency(realTar, outputDir)).To(Succeed())
+			Expect(installer.InstallDependency(realTar, outputDir)).To(Succeed())
+		})
+
+		It("records it once", func() {
+			Expect(manifest.InstalledDependencies()).To(Equal([]libbuildpack.DependencyFingerprint{
+				{Name: "real_tar_file", Version: "3", SHA256: "8208480eb849203632239f73bd3c61ed488546d19d29c06d7c2e1649d8950bd1"},
+			}))
+		})
+
+		It("stores it with the stack in BUILDPACK_METADATA", func() {
+			Expect(manifest.RecordDependency(thing)).To(Succeed())
+			Expect(manifest.StoreBuildpackMetadata(cacheDir)).To(Succeed())
+
+			md, found, err := manifest.PreviousBuildpackMetadata(cacheDir)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeTrue())
+			Expect(md.Stack).To(Equal("cflinuxfs2"))
+			Expect(md.Dependencies).To(HaveLen(2))
+			Expect(md.Dependencies[1].Name).To(Equal("thing"))
+		})
+
+		It("reports no changes on the next staging", func() {
+			Expect(manifest.StoreBuildpackMetadata(cacheDir)).To(Succeed())
+
+			Expect(manifest.ChangedDependencies(cacheDir, realTar)).To(BeEmpty())
+		})
+	})
+
+	Describe("ChangedDependencies", func() {
+		It("reports every dependency without a previous staging", func() {
+			Expect(manifest.ChangedDependencies(cacheDir, realTar, thing)).To(Equal([]string{"real_tar_file", "thing"}))
+		})
+
+		It("reports dependencies whose version changed", func() {
+			writeMetadata(`---
+language: sample
+version: 1.0.0
+stack: cflinuxfs2
+dependencies:
+- name: real_tar_file
+  version: "3"
+  sha256: 8208480eb849203632239f73bd3c61ed488546d19d29c06d7c2e1649d8950bd1
+- name: thing
+  version: "2"
+  sha256: 191e76317fb5ba7f118a74ff9fbba0616f6ea5a2e0c5eaaff83e2d43eb5ef81c
+`)
+			Expect(manifest.ChangedDependencies(cacheDir, realTar, thing)).To(Equal([]string{"thing"}))
+		})
+
+		It("reports dependencies that were rebuilt with another sha256", func() {
+			writeMetadata(`---
+language: sample
+version: 1.0.0
+stack: cflinuxfs2
+dependencies:
+- name: real_tar_file
+  version: "3"
+  sha256: 0000000000000000000000000000000000000000000000000000000000000000
+`)
+			Expect(manifest.ChangedDependencies(cacheDir, realTar)).To(Equal([]string{"real_tar_file"}))
+		})
+
+		It("reports every dependency when the stack changed", func() {
+			writeMetadata(`---
+language: sample
+version: 1.0.0
+stack: cflinuxfs1
+dependencies:
+- name: real_tar_file
+  version: "3"
+  sha256: 8208480eb849203632239f73bd3c61ed488546d19d29c06d7c2e1649d8950bd1
+`)
+			Expect(manifest.ChangedDependencies(cacheDir, realTar)).To(Equal([]string{"real_tar_file"}))
+		})
+
+		It("ignores metadata of another buildpack", func() {
+			writeMetadata("---\nlanguage: ruby\nversion: 1.0.0\nstack: cflinuxfs2\n")
+
+			_, found, err := manifest.PreviousBuildpackMetadata(cacheDir)
+			Expect(err).To(BeNil())
+			Expect(found).To(BeFalse())
+		})
+
+		It("returns an error for unknown dependencies", func() {
+			_, err := manifest.ChangedDependencies(cacheDir, libbuildpack.Dependency{Name: "missing", Version: "1"})
+			Expect(err).To(MatchError("dependency missing 1 not found"))
+		})
+	})
+})
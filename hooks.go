@@ -1,6 +1,11 @@
 package libbuildpack
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
 	"sync"
 )
 
@@ -9,39 +14,334 @@ type Hook interface {
 	AfterCompile(*Stager) error
 }
 
-var hookArray []Hook
-var hookArrayLock sync.Mutex
+// Hooks can opt into more lifecycle points by also implementing any of the
+// interfaces below. DefaultHook implements all of them as no-ops, so hooks
+// that embed it only override the points they care about.
+
+type SupplyHook interface {
+	BeforeSupply(*Stager) error
+	AfterSupply(*Stager) error
+}
+
+type FinalizeHook interface {
+	BeforeFinalize(*Stager) error
+	AfterFinalize(*Stager) error
+}
+
+// ReleaseHook runs before WriteRelease writes release, and may change it.
+type ReleaseHook interface {
+	BeforeRelease(*Stager, *Release) error
+}
+
+// FailureHook runs when supply or finalize fail, with the error they failed
+// with.
+type FailureHook interface {
+	OnFailure(*Stager, error) error
+}
+
+// ContextHook is implemented by hooks that can stop their work when the
+// context of a lifecycle point is done. The runners call the hook returned by
+// WithContext and wait for it to return; it must implement the same lifecycle
+// interfaces as the hook itself, or the lifecycle point fails. Other hooks cannot be stopped: the
+// runners fail them when the context is done and leave them running.
+type ContextHook interface {
+	WithContext(ctx context.Context) Hook
+}
+
+// NamedHook names a hook in logs. Hooks without a name are named after their
+// type.
+type NamedHook interface {
+	Name() string
+}
+
+// PrioritizedHook orders hooks: lower priorities run first, and hooks with
+// the same priority run in the order they were added. Hooks without a
+// priority have priority 0.
+type PrioritizedHook interface {
+	Priority() int
+}
+
+// HookRegistry holds hooks in the order they run. Every Stager has its own
+// registry; hooks added with AddHook go to a package wide registry that all
+// Stagers run as well.
+type HookRegistry struct {
+	lock  sync.Mutex
+	hooks []Hook
+}
+
+var globalHooks = NewHookRegistry()
+
+func NewHookRegistry() *HookRegistry {
+	return &HookRegistry{}
+}
+
+func (r *HookRegistry) Add(hook Hook) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.hooks = append(r.hooks, hook)
+}
+
+func (r *HookRegistry) Clear() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.hooks = nil
+}
+
+// Hooks returns the hooks of the registry in the order they run.
+func (r *HookRegistry) Hooks() []Hook {
+	r.lock.Lock()
+	hooks := append([]Hook{}, r.hooks...)
+	r.lock.Unlock()
+
+	sort.SliceStable(hooks, func(i, j int) bool { return HookPriority(hooks[i]) < HookPriority(hooks[j]) })
+	return hooks
+}
 
 func AddHook(hook Hook) {
-	hookArrayLock.Lock()
-	hookArray = append(hookArray, hook)
-	hookArrayLock.Unlock()
+	globalHooks.Add(hook)
 }
+
 func ClearHooks() {
-	hookArrayLock.Lock()
-	hookArray = make([]Hook, 0)
-	hookArrayLock.Unlock()
+	globalHooks.Clear()
 }
 
+func HookName(hook Hook) string {
+	if named, ok := hook.(NamedHook); ok && named.Name() != "" {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", hook)
+}
+
+func HookPriority(hook Hook) int {
+	if prioritized, ok := hook.(PrioritizedHook); ok {
+		return prioritized.Priority()
+	}
+	return 0
+}
+
+// The Run functions run a lifecycle point of every hook of stager, and stop at
+// the first error. Each hook has the budget set in HookTimeoutEnv; their
+// Context variants also stop when ctx is done.
+
 func RunBeforeCompile(stager *Stager) error {
-	for _, hook := range hookArray {
-		if err := hook.BeforeCompile(stager); err != nil {
+	return RunBeforeCompileContext(context.Background(), stager)
+}
+
+func RunBeforeCompileContext(ctx context.Context, stager *Stager) error {
+	return runHooks(ctx, stager, "BeforeCompile", func(hook Hook) error { return hook.BeforeCompile(stager) })
+}
+
+func RunAfterCompile(stager *Stager) error {
+	return RunAfterCompileContext(context.Background(), stager)
+}
+
+func RunAfterCompileContext(ctx context.Context, stager *Stager) error {
+	return runHooks(ctx, stager, "AfterCompile", func(hook Hook) error { return hook.AfterCompile(stager) })
+}
+
+func RunBeforeSupply(stager *Stager) error {
+	return RunBeforeSupplyContext(context.Background(), stager)
+}
+
+func RunBeforeSupplyContext(ctx context.Context, stager *Stager) error {
+	return runHooks(ctx, stager, "BeforeSupply", func(hook Hook) error {
+		if h, ok := hook.(SupplyHook); ok {
+			return h.BeforeSupply(stager)
+		}
+		return nil
+	})
+}
+
+func RunAfterSupply(stager *Stager) error {
+	return RunAfterSupplyContext(context.Background(), stager)
+}
+
+func RunAfterSupplyContext(ctx context.Context, stager *Stager) error {
+	return runHooks(ctx, stager, "AfterSupply", func(hook Hook) error {
+		if h, ok := hook.(SupplyHook); ok {
+			return h.AfterSupply(stager)
+		}
+		return nil
+	})
+}
+
+func RunBeforeFinalize(stager *Stager) error {
+	return RunBeforeFinalizeContext(context.Background(), stager)
+}
+
+func RunBeforeFinalizeContext(ctx context.Context, stager *Stager) error {
+	return runHooks(ctx, stager, "BeforeFinalize", func(hook Hook) error {
+		if h, ok := hook.(FinalizeHook); ok {
+			return h.BeforeFinalize(stager)
+		}
+		return nil
+	})
+}
+
+func RunAfterFinalize(stager *Stager) error {
+	return RunAfterFinalizeContext(context.Background(), stager)
+}
+
+func RunAfterFinalizeContext(ctx context.Context, stager *Stager) error {
+	return runHooks(ctx, stager, "AfterFinalize", func(hook Hook) error {
+		if h, ok := hook.(FinalizeHook); ok {
+			return h.AfterFinalize(stager)
+		}
+		return nil
+	})
+}
+
+func RunBeforeRelease(stager *Stager, release *Release) error {
+	return RunBeforeReleaseContext(context.Background(), stager, release)
+}
+
+func RunBeforeReleaseContext(ctx context.Context, stager *Stager, release *Release) error {
+	return runHooks(ctx, stager, "BeforeRelease", func(hook Hook) error {
+		if h, ok := hook.(ReleaseHook); ok {
+			return h.BeforeRelease(stager, release)
+		}
+		return nil
+	})
+}
+
+// RunOnFailure runs every FailureHook, even if some of them fail, and returns
+// the first error.
+func RunOnFailure(stager *Stager, failure error) error {
+	return RunOnFailureContext(context.Background(), stager, failure)
+}
+
+func RunOnFailureContext(ctx context.Context, stager *Stager, failure error) error {
+	var firstErr error
+	for _, hook := range stagerHooks(stager) {
+		if _, ok := hook.(FailureHook); !ok {
+			continue
+		}
+		err := runHook(ctx, stager, "OnFailure", hook, func(hook Hook) error {
+			return hook.(FailureHook).OnFailure(stager, failure)
+		})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func runHooks(ctx context.Context, stager *Stager, point string, run func(Hook) error) error {
+	for _, hook := range stagerHooks(stager) {
+		if err := runHook(ctx, stager, point, hook, run); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func RunAfterCompile(stager *Stager) error {
-	for _, hook := range hookArray {
-		if err := hook.AfterCompile(stager); err != nil {
-			return err
+// runHook runs point of hook within its budget. A hook that panics fails
+// with the panic as its error. Context hooks run until they return; other
+// hooks fail when the budget is up, and are left running in the background
+// with a warning.
+func runHook(ctx context.Context, stager *Stager, point string, hook Hook, run func(Hook) error) error {
+	name := HookName(hook)
+	if stager != nil && stager.log != nil {
+		stager.log.Debug("Running %s hook %s", point, name)
+	}
+
+	ctx, cancel, op, err := startOperation(ctx, fmt.Sprintf("%s hook %s", point, name), HookTimeoutEnv)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if contextHook, ok := hook.(ContextHook); ok {
+		withContext := contextHook.WithContext(ctx)
+		if err := checkHookInterfaces(hook, withContext); err != nil {
+			return fmt.Errorf("%s hook %s: %w", point, name, err)
+		}
+		err = callHook(stager, point, name, withContext, run)
+		if err == nil {
+			err = ctx.Err()
+		}
+		return op.result(ctx, err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- callHook(stager, point, name, hook, run) }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			err = ctx.Err()
+		}
+		return op.result(ctx, err)
+	case <-ctx.Done():
+		if stager != nil && stager.log != nil {
+			stager.log.Warning("%s hook %s cannot be stopped and is still running", point, name)
+		}
+		return op.result(ctx, ctx.Err())
+	}
+}
+
+func callHook(stager *Stager, point, name string, hook Hook, run func(Hook) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if stager != nil && stager.log != nil {
+				stager.log.Debug("%s", debug.Stack())
+			}
+			err = fmt.Errorf("%s hook %s panicked: %v", point, name, r)
+		}
+	}()
+	return run(hook)
+}
+
+// checkHookInterfaces fails if the hook returned by WithContext lost a
+// lifecycle point of hook, which the runners would skip otherwise.
+func checkHookInterfaces(hook, withContext Hook) error {
+	if _, ok := hook.(SupplyHook); ok {
+		if _, ok := withContext.(SupplyHook); !ok {
+			return errors.New("WithContext returned a hook that is not a SupplyHook")
+		}
+	}
+	if _, ok := hook.(FinalizeHook); ok {
+		if _, ok := withContext.(FinalizeHook); !ok {
+			return errors.New("WithContext returned a hook that is not a FinalizeHook")
+		}
+	}
+	if _, ok := hook.(ReleaseHook); ok {
+		if _, ok := withContext.(ReleaseHook); !ok {
+			return errors.New("WithContext returned a hook that is not a ReleaseHook")
+		}
+	}
+	if _, ok := hook.(FailureHook); ok {
+		if _, ok := withContext.(FailureHook); !ok {
+			return errors.New("WithContext returned a hook that is not a FailureHook")
 		}
 	}
 	return nil
 }
 
+// stagerHooks returns the hooks of stager and the package wide hooks, in the
+// order they run.
+func stagerHooks(stager *Stager) []Hook {
+	combined := NewHookRegistry()
+	for _, hook := range globalHooks.Hooks() {
+		combined.Add(hook)
+	}
+	if stager != nil && stager.hooks != nil {
+		for _, hook := range stager.hooks.Hooks() {
+			combined.Add(hook)
+		}
+	}
+	return combined.Hooks()
+}
+
 type DefaultHook struct{}
 
-func (d DefaultHook) BeforeCompile(stager *Stager) error { return nil }
-func (d DefaultHook) AfterCompile(stager *Stager) error  { return nil }
+func (d DefaultHook) BeforeCompile(stager *Stager) error                   { return nil }
+func (d DefaultHook) AfterCompile(stager *Stager) error                    { return nil }
+func (d DefaultHook) BeforeSupply(stager *Stager) error                    { return nil }
+func (d DefaultHook) AfterSupply(stager *Stager) error                     { return nil }
+func (d DefaultHook) BeforeFinalize(stager *Stager) error                  { return nil }
+func (d DefaultHook) AfterFinalize(stager *Stager) error                   { return nil }
+func (d DefaultHook) BeforeRelease(stager *Stager, release *Release) error { return nil }
+func (d DefaultHook) OnFailure(stager *Stager, failure error) error        { return nil }
+func (d DefaultHook) Name() string                                         { return "" }
+func (d DefaultHook) Priority() int                                        { return 0 }
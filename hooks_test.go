@@ -1,7 +1,10 @@
 package libbuildpack_test
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 
 	bp "github.com/cloudfoundry/libbuildpack"
 	"github.com/golang/mock/gomock"
@@ -63,5 +66,153 @@ var _ = Describe("Hooks", func() {
 			hook = bp.DefaultHook{}
 			Expect(hook).ToNot(BeNil())
 		})
+
+		It("fulfils the optional lifecycle interfaces", func() {
+			var (
+				_ bp.SupplyHook   = bp.DefaultHook{}
+				_ bp.FinalizeHook = bp.DefaultHook{}
+				_ bp.ReleaseHook  = bp.DefaultHook{}
+				_ bp.FailureHook  = bp.DefaultHook{}
+			)
+			Expect(bp.HookName(bp.DefaultHook{})).To(Equal("libbuildpack.DefaultHook"))
+			Expect(bp.HookPriority(bp.DefaultHook{})).To(Equal(0))
+		})
+	})
+
+	Describe("lifecycle", func() {
+		var (
+			events []string
+			stager *bp.Stager
+		)
+
+		BeforeEach(func() {
+			events = nil
+			stager = bp.NewStager([]string{"", "", "", ""}, bp.NewLogger(new(bytes.Buffer)), nil)
+		})
+
+		It("runs every lifecycle point of hooks that implement it", func() {
+			stager.Hooks().Add(&recordingHook{name: "rec", events: &events})
+			bp.AddHook(mockHook)
+			mockHook.EXPECT().BeforeCompile(stager)
+
+			Expect(bp.RunBeforeCompile(stager)).To(Succeed())
+			Expect(bp.RunBeforeSupply(stager)).To(Succeed())
+			Expect(bp.RunAfterSupply(stager)).To(Succeed())
+			Expect(bp.RunBeforeFinalize(stager)).To(Succeed())
+			Expect(bp.RunAfterFinalize(stager)).To(Succeed())
+			Expect(bp.RunBeforeRelease(stager, &bp.Release{})).To(Succeed())
+			Expect(bp.RunOnFailure(stager, errors.New("boom"))).To(Succeed())
+
+			Expect(events).To(Equal([]string{
+				"rec BeforeCompile",
+				"rec BeforeSupply",
+				"rec AfterSupply",
+				"rec BeforeFinalize",
+				"rec AfterFinalize",
+				"rec BeforeRelease",
+				"rec OnFailure boom",
+			}))
+		})
+
+		It("orders hooks by priority, then by the order they were added", func() {
+			stager.Hooks().Add(&recordingHook{name: "late", priority: 10, events: &events})
+			stager.Hooks().Add(&recordingHook{name: "first", priority: -5, events: &events})
+			bp.AddHook(&recordingHook{name: "global", events: &events})
+			stager.Hooks().Add(&recordingHook{name: "default", events: &events})
+
+			Expect(bp.RunBeforeSupply(stager)).To(Succeed())
+			Expect(events).To(Equal([]string{"first BeforeSupply", "global BeforeSupply", "default BeforeSupply", "late BeforeSupply"}))
+		})
+
+		It("keeps hooks of different stagers apart", func() {
+			other := bp.NewStager([]string{"", "", "", ""}, bp.NewLogger(new(bytes.Buffer)), nil)
+			other.Hooks().Add(&recordingHook{name: "other", events: &events})
+
+			Expect(bp.RunBeforeSupply(stager)).To(Succeed())
+			Expect(events).To(BeEmpty())
+		})
+
+		It("stops at the first failing hook", func() {
+			stager.Hooks().Add(&recordingHook{name: "fails", fail: errors.New("no"), events: &events})
+			stager.Hooks().Add(&recordingHook{name: "skipped", events: &events})
+
+			Expect(bp.RunAfterFinalize(stager)).To(MatchError("no"))
+			Expect(events).To(Equal([]string{"fails AfterFinalize"}))
+		})
+
+		It("fails hooks that panic", func() {
+			stager.Hooks().Add(&recordingHook{name: "panics", panic: "nil map", events: &events})
+			stager.Hooks().Add(&recordingHook{name: "skipped", events: &events})
+
+			Expect(bp.RunBeforeSupply(stager)).To(MatchError("BeforeSupply hook panics panicked: nil map"))
+			Expect(events).To(Equal([]string{"panics BeforeSupply"}))
+		})
+
+		It("runs all failure hooks and returns the first error", func() {
+			stager.Hooks().Add(&recordingHook{name: "fails", fail: errors.New("no"), events: &events})
+			stager.Hooks().Add(&recordingHook{name: "runs", events: &events})
+
+			Expect(bp.RunOnFailure(stager, errors.New("boom"))).To(MatchError("no"))
+			Expect(events).To(Equal([]string{"fails OnFailure boom", "runs OnFailure boom"}))
+		})
+
+		It("fails context hooks whose WithContext loses a lifecycle point", func() {
+			stager.Hooks().Add(&wrappingHook{recordingHook: recordingHook{name: "wraps", events: &events}})
+
+			Expect(bp.RunOnFailure(stager, errors.New("boom"))).To(MatchError("OnFailure hook wraps: WithContext returned a hook that is not a SupplyHook"))
+			Expect(events).To(BeEmpty())
+		})
+
+		It("names hooks after their type unless they have a name", func() {
+			Expect(bp.HookName(&recordingHook{name: "rec"})).To(Equal("rec"))
+			Expect(bp.HookName(&recordingHook{})).To(Equal("*libbuildpack_test.recordingHook"))
+			Expect(bp.HookName(mockHook)).To(Equal("*libbuildpack_test.MockHook"))
+		})
 	})
 })
+
+type recordingHook struct {
+	bp.DefaultHook
+	name     string
+	priority int
+	fail     error
+	panic    interface{}
+	events   *[]string
+}
+
+func (h *recordingHook) record(event string) error {
+	*h.events = append(*h.events, h.name+" "+event)
+	if h.panic != nil {
+		panic(h.panic)
+	}
+	return h.fail
+}
+
+func (h *recordingHook) Name() string                    { return h.name }
+func (h *recordingHook) Priority() int                   { return h.priority }
+func (h *recordingHook) BeforeCompile(*bp.Stager) error  { return h.record("BeforeCompile") }
+func (h *recordingHook) AfterCompile(*bp.Stager) error   { return h.record("AfterCompile") }
+func (h *recordingHook) BeforeSupply(*bp.Stager) error   { return h.record("BeforeSupply") }
+func (h *recordingHook) AfterSupply(*bp.Stager) error    { return h.record("AfterSupply") }
+func (h *recordingHook) BeforeFinalize(*bp.Stager) error { return h.record("BeforeFinalize") }
+func (h *recordingHook) AfterFinalize(*bp.Stager) error  { return h.record("AfterFinalize") }
+func (h *recordingHook) BeforeRelease(*bp.Stager, *bp.Release) error {
+	return h.record("BeforeRelease")
+}
+func (h *recordingHook) OnFailure(_ *bp.Stager, failure error) error {
+	return h.record(fmt.Sprintf("OnFailure %s", failure))
+}
+
+// wrappingHook returns a hook with only the compile points from WithContext.
+type wrappingHook struct {
+	recordingHook
+}
+
+func (h *wrappingHook) WithContext(context.Context) bp.Hook {
+	return compileOnlyHook{}
+}
+
+type compileOnlyHook struct{}
+
+func (compileOnlyHook) BeforeCompile(*bp.Stager) error { return nil }
+func (compileOnlyHook) AfterCompile(*bp.Stager) error  { return nil }
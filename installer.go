@@ -1,11 +1,13 @@
 package libbuildpack
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
@@ -34,6 +36,12 @@ func (i *Installer) InstallDependency(dep Dependency, outputDir string) error {
 	return i.InstallDependencyWithStrip(dep, outputDir, 0)
 }
 
+// InstallDependencyContext is InstallDependency, but stops fetching dep when
+// ctx is done.
+func (i *Installer) InstallDependencyContext(ctx context.Context, dep Dependency, outputDir string) error {
+	return i.InstallDependencyWithStripContext(ctx, dep, outputDir, 0)
+}
+
 // InstallDependencyWithStrip installs a dependency with optional path stripping
 // stripComponents works like tar's --strip-components flag:
 //
@@ -44,8 +52,23 @@ func (i *Installer) InstallDependency(dep Dependency, outputDir string) error {
 // This is useful for archives that extract to a top-level directory
 // (e.g., apache-tomcat-9.0.98.tar.gz extracts to apache-tomcat-9.0.98/)
 func (i *Installer) InstallDependencyWithStrip(dep Dependency, outputDir string, stripComponents int) error {
+	return i.InstallDependencyWithStripContext(context.Background(), dep, outputDir, stripComponents)
+}
+
+// InstallDependencyWithStripContext is InstallDependencyWithStrip, but stops
+// fetching dep when ctx is done.
+func (i *Installer) InstallDependencyWithStripContext(ctx context.Context, dep Dependency, outputDir string, stripComponents int) error {
 	i.manifest.log.BeginStep("Installing %s %s", dep.Name, dep.Version)
 
+	entry, err := i.manifest.GetEntry(dep)
+	if err != nil {
+		return err
+	}
+
+	if Planning() {
+		return i.planDependency(entry, outputDir, stripComponents)
+	}
+
 	tmpDir, err := os.MkdirTemp("", "downloads")
 	if err != nil {
 		return err
@@ -54,17 +77,65 @@ func (i *Installer) InstallDependencyWithStrip(dep Dependency, outputDir string,
 
 	tmpFile := filepath.Join(tmpDir, "archive")
 
-	entry, err := i.manifest.GetEntry(dep)
+	err = i.FetchDependencyContext(ctx, dep, tmpFile)
 	if err != nil {
 		return err
 	}
 
-	err = i.FetchDependency(dep, tmpFile)
+	return i.installFetchedDependency(dep, entry, tmpFile, outputDir, stripComponents)
+}
+
+// InstallMatching installs the highest version of depName matching
+// constraint. If that version cannot be fetched or fails verification, the
+// next highest matching version is tried instead, and a warning names the
+// version that was substituted and why.
+func (i *Installer) InstallMatching(depName, constraint, outputDir string) (Dependency, error) {
+	versions, err := FindMatchingVersions(constraint, i.manifest.AllDependencyVersions(depName))
 	if err != nil {
-		return err
+		return Dependency{}, err
+	}
+
+	if Planning() {
+		dep := Dependency{Name: depName, Version: versions[len(versions)-1]}
+		return dep, i.InstallDependency(dep, outputDir)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "downloads")
+	if err != nil {
+		return Dependency{}, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpFile := filepath.Join(tmpDir, "archive")
+
+	var failures []string
+	for idx := len(versions) - 1; idx >= 0; idx-- {
+		dep := Dependency{Name: depName, Version: versions[idx]}
+		i.manifest.log.BeginStep("Installing %s %s", dep.Name, dep.Version)
+
+		entry, err := i.manifest.GetEntry(dep)
+		if err != nil {
+			return Dependency{}, err
+		}
+
+		if err := i.FetchDependency(dep, tmpFile); err != nil {
+			i.manifest.log.Warning("Could not fetch %s %s: %v", dep.Name, dep.Version, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", dep.Version, err))
+			continue
+		}
+
+		if len(failures) > 0 {
+			i.manifest.log.Warning(substitutedVersionWarning(dep, versions[len(versions)-1], constraint))
+		}
+
+		return dep, i.installFetchedDependency(dep, entry, tmpFile, outputDir, 0)
 	}
 
-	err = i.warnNewerPatch(dep)
+	return Dependency{}, fmt.Errorf("could not fetch any version of %s matching %s: %s", depName, constraint, strings.Join(failures, "; "))
+}
+
+func (i *Installer) installFetchedDependency(dep Dependency, entry *ManifestEntry, tmpFile, outputDir string, stripComponents int) error {
+	err := i.warnNewerPatch(dep)
 	if err != nil {
 		return err
 	}
@@ -75,7 +146,11 @@ func (i *Installer) InstallDependencyWithStrip(dep Dependency, outputDir string,
 	}
 
 	if strings.HasSuffix(entry.URI, ".sh") {
-		return os.Rename(tmpFile, outputDir)
+		if err := os.Rename(tmpFile, outputDir); err != nil {
+			return err
+		}
+		i.manifest.recordInstalled(entry)
+		return nil
 	}
 
 	err = os.MkdirAll(outputDir, 0755)
@@ -83,6 +158,23 @@ func (i *Installer) InstallDependencyWithStrip(dep Dependency, outputDir string,
 		return err
 	}
 
+	if err := checkFreeSpace(entry, tmpFile, outputDir); err != nil {
+		return err
+	}
+
+	if err := extractDependency(entry, tmpFile, outputDir, stripComponents); err != nil {
+		return err
+	}
+
+	if err := i.relocate(entry, outputDir); err != nil {
+		return err
+	}
+
+	i.manifest.recordInstalled(entry)
+	return nil
+}
+
+func extractDependency(entry *ManifestEntry, tmpFile, outputDir string, stripComponents int) error {
 	if strings.HasSuffix(entry.URI, ".zip") {
 		if stripComponents > 0 {
 			return ExtractZipWithStrip(tmpFile, outputDir, stripComponents)
@@ -179,6 +271,12 @@ func (i *Installer) warnEndOfLife(dep Dependency) error {
 }
 
 func (i *Installer) FetchDependency(dep Dependency, outputFile string) error {
+	return i.FetchDependencyContext(context.Background(), dep, outputFile)
+}
+
+// FetchDependencyContext is FetchDependency, but stops downloading when ctx
+// is done or the budget set in DownloadTimeoutEnv runs out.
+func (i *Installer) FetchDependencyContext(ctx context.Context, dep Dependency, outputFile string) error {
 	entry, err := i.manifest.GetEntry(dep)
 	if err != nil {
 		return err
@@ -188,11 +286,17 @@ func (i *Installer) FetchDependency(dep Dependency, outputFile string) error {
 		return fetchCachedBuildpackDependency(entry, outputFile, i.manifest.manifestRootDir, i.manifest.log)
 	}
 
+	ctx, cancel, op, err := startOperation(ctx, fmt.Sprintf("downloading %s %s", dep.Name, dep.Version), DownloadTimeoutEnv)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
 	if i.appCacheDir != "" { // this buildpack caches dependencies in the app cache
-		return i.fetchAppCachedBuildpackDependency(entry, outputFile)
+		return op.result(ctx, i.fetchAppCachedBuildpackDependency(ctx, entry, outputFile))
 	}
 
-	return downloadDependency(entry, outputFile, i.manifest.log, i.retryTimeLimit, i.retryTimeInitialInterval)
+	return op.result(ctx, downloadDependency(ctx, entry, outputFile, i.manifest.log, i.retryTimeLimit, i.retryTimeInitialInterval))
 }
 
 func (i *Installer) CleanupAppCache() error {
@@ -217,6 +321,12 @@ func (i *Installer) CleanupAppCache() error {
 	}
 
 	for _, path := range pathsToDelete {
+		if Planning() {
+			if err := i.manifest.recordPlanAction(PlanDelete, path, nil); err != nil {
+				return err
+			}
+			continue
+		}
 		i.manifest.log.Debug("Deleting cached file: %s", path)
 		if err := os.RemoveAll(path); err != nil {
 			return fmt.Errorf("Failed while cleaning up app cache; couldn't delete %s because: %v", path, err)
@@ -244,9 +354,87 @@ func (i *Installer) InstallOnlyVersionWithStrip(depName string, installDir strin
 	return i.InstallDependencyWithStrip(dep, installDir, stripComponents)
 }
 
-func (i *Installer) fetchAppCachedBuildpackDependency(entry *ManifestEntry, outputFile string) error {
-	shaURI := sha256.Sum256([]byte(entry.URI))
-	cacheFile := filepath.Join(i.appCacheDir, hex.EncodeToString(shaURI[:]), filepath.Base(entry.URI))
+// InstallVersions installs the highest version of depName matching each of
+// constraints side by side, into <baseDir>/<depName>/<version>, and returns
+// the install directory of each version. The version matching the first
+// constraint is the active default. It is written to
+// <baseDir>/<depName>/default_version, see InstalledDefaultVersion, and
+// except on Windows, where symlinks need privileges, linked from
+// <baseDir>/<depName>/default.
+func (i *Installer) InstallVersions(depName string, constraints []string, baseDir string) (map[string]string, error) {
+	if len(constraints) == 0 {
+		return nil, fmt.Errorf("no versions of %s requested", depName)
+	}
+
+	depVersions := i.manifest.AllDependencyVersions(depName)
+	depDir := filepath.Join(baseDir, depName)
+	installed := map[string]string{}
+	defaultVersion := ""
+
+	for _, constraint := range constraints {
+		version, err := FindMatchingVersion(constraint, depVersions)
+		if err != nil {
+			return nil, err
+		}
+		if defaultVersion == "" {
+			defaultVersion = version
+		}
+		if _, found := installed[version]; found {
+			continue
+		}
+
+		installDir := filepath.Join(depDir, version)
+		if err := i.InstallDependency(Dependency{Name: depName, Version: version}, installDir); err != nil {
+			return nil, err
+		}
+		installed[version] = installDir
+	}
+
+	defaultFile := filepath.Join(depDir, defaultVersionFile)
+	defaultLink := filepath.Join(depDir, "default")
+	if Planning() {
+		i.manifest.log.Info("Using %s %s as the default", depName, defaultVersion)
+		if err := i.manifest.recordPlanAction(PlanWriteFile, defaultFile, map[string]string{"contents": defaultVersion}); err != nil {
+			return nil, err
+		}
+		if runtime.GOOS == "windows" {
+			return installed, nil
+		}
+		return installed, i.manifest.recordPlanAction(PlanSymlink, defaultLink, map[string]string{"source": defaultVersion})
+	}
+
+	if err := writeToFile(strings.NewReader(defaultVersion), defaultFile, 0644); err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" {
+		if _, err := os.Lstat(defaultLink); err == nil {
+			if err := os.Remove(defaultLink); err != nil {
+				return nil, err
+			}
+		}
+		if err := os.Symlink(defaultVersion, defaultLink); err != nil {
+			return nil, err
+		}
+	}
+	i.manifest.log.Info("Using %s %s as the default", depName, defaultVersion)
+
+	return installed, nil
+}
+
+const defaultVersionFile = "default_version"
+
+// InstalledDefaultVersion returns the default version of depName recorded by
+// InstallVersions in baseDir, e.g. in a later phase.
+func InstalledDefaultVersion(baseDir, depName string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(baseDir, depName, defaultVersionFile))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (i *Installer) fetchAppCachedBuildpackDependency(ctx context.Context, entry *ManifestEntry, outputFile string) error {
+	cacheFile := i.appCacheFile(entry)
 
 	i.filesInAppCache[cacheFile] = true
 	i.filesInAppCache[filepath.Dir(cacheFile)] = true
@@ -264,7 +452,7 @@ func (i *Installer) fetchAppCachedBuildpackDependency(entry *ManifestEntry, outp
 		return deleteBadFile(entry, outputFile)
 	}
 
-	if err := downloadDependency(entry, outputFile, i.manifest.log, i.retryTimeLimit, i.retryTimeInitialInterval); err != nil {
+	if err := downloadDependency(ctx, entry, outputFile, i.manifest.log, i.retryTimeLimit, i.retryTimeInitialInterval); err != nil {
 		return err
 	}
 	if err := CopyFile(outputFile, cacheFile); err != nil {
@@ -274,6 +462,11 @@ func (i *Installer) fetchAppCachedBuildpackDependency(entry *ManifestEntry, outp
 	return nil
 }
 
+func (i *Installer) appCacheFile(entry *ManifestEntry) string {
+	shaURI := sha256.Sum256([]byte(entry.URI))
+	return filepath.Join(i.appCacheDir, hex.EncodeToString(shaURI[:]), filepath.Base(entry.URI))
+}
+
 func (i *Installer) SetVersionLine(depName string, line string) {
 	(*i.versionLine)[depName] = line
 }
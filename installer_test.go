@@ -1,6 +1,7 @@
 package libbuildpack_test
 
 import (
+	"archive/zip"
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
@@ -8,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
 	"time"
 
 	"github.com/cloudfoundry/libbuildpack"
@@ -514,6 +516,43 @@ var _ = Describe("Installer", func() {
 			DeferCleanup(os.RemoveAll, outputDir)
 		})
 
+		Context("the archive extracts to more than the free disk space", func() {
+			BeforeEach(func() {
+				manifestDir, err = os.MkdirTemp("", "manifest")
+				Expect(err).To(BeNil())
+				DeferCleanup(os.RemoveAll, manifestDir)
+
+				// A stored entry claiming an exabyte of data, as only the
+				// headers of zip files are read before extracting.
+				archive := new(bytes.Buffer)
+				w := zip.NewWriter(archive)
+				entry, err := w.CreateRaw(&zip.FileHeader{Name: "huge.bin", Method: zip.Store, CompressedSize64: 4, UncompressedSize64: 1 << 60})
+				Expect(err).To(BeNil())
+				_, err = entry.Write([]byte("huge"))
+				Expect(err).To(BeNil())
+				Expect(w.Close()).To(Succeed())
+
+				sum := sha256.Sum256(archive.Bytes())
+				Expect(libbuildpack.NewYAML().Write(filepath.Join(manifestDir, "manifest.yml"), libbuildpack.Manifest{
+					LanguageString: "sample",
+					ManifestEntries: []libbuildpack.ManifestEntry{{
+						Dependency: libbuildpack.Dependency{Name: "huge", Version: "1.0.0"},
+						URI:        "https://example.com/dependencies/huge-1.0.0.zip",
+						SHA256:     hex.EncodeToString(sum[:]),
+						CFStacks:   []string{"cflinuxfs2"},
+					}},
+				})).To(Succeed())
+				httpmock.RegisterResponder("GET", "https://example.com/dependencies/huge-1.0.0.zip",
+					httpmock.NewBytesResponder(200, archive.Bytes()))
+			})
+
+			It("fails before extracting, sizing the download by the type of its URI", func() {
+				err = installer.InstallDependency(libbuildpack.Dependency{Name: "huge", Version: "1.0.0"}, outputDir)
+				Expect(err).To(MatchError(ContainSubstring("not enough disk space to install huge 1.0.0")))
+				Expect(filepath.Join(outputDir, "huge.bin")).NotTo(BeAnExistingFile())
+			})
+		})
+
 		Context("uncached", func() {
 			BeforeEach(func() {
 				manifestDir = "fixtures/manifest/fetch"
@@ -967,6 +1006,188 @@ var _ = Describe("Installer", func() {
 		})
 	})
 
+	Describe("InstallMatching", func() {
+		var outputDir string
+
+		BeforeEach(func() {
+			outputDir, err = os.MkdirTemp("", "downloads")
+			Expect(err).To(BeNil())
+			DeferCleanup(os.RemoveAll, outputDir)
+
+			manifestDir, err = os.MkdirTemp("", "buildpack")
+			Expect(err).To(BeNil())
+			DeferCleanup(os.RemoveAll, manifestDir)
+
+			var entries []libbuildpack.ManifestEntry
+			for _, version := range []string{"1.2.3", "1.2.4", "1.3.0", "2.0.0"} {
+				entries = append(entries, libbuildpack.ManifestEntry{
+					Dependency: libbuildpack.Dependency{Name: "thing", Version: version},
+					URI:        "https://example.com/dependencies/thing-" + version + ".tgz",
+					SHA256:     "8208480eb849203632239f73bd3c61ed488546d19d29c06d7c2e1649d8950bd1",
+					CFStacks:   []string{"cflinuxfs2"},
+				})
+			}
+			y := libbuildpack.NewYAML()
+			Expect(y.Write(filepath.Join(manifestDir, "manifest.yml"), libbuildpack.Manifest{LanguageString: "sample", ManifestEntries: entries})).To(Succeed())
+
+			tgzContents, err := os.ReadFile("fixtures/thing.tgz")
+			Expect(err).To(BeNil())
+			for _, version := range []string{"1.2.3", "1.2.4", "1.3.0", "2.0.0"} {
+				httpmock.RegisterResponder("GET", "https://example.com/dependencies/thing-"+version+".tgz",
+					httpmock.NewStringResponder(200, string(tgzContents)))
+			}
+		})
+
+		Context("the highest matching version can be fetched", func() {
+			It("installs it", func() {
+				dep, err := installer.InstallMatching("thing", "1.x", outputDir)
+				Expect(err).To(BeNil())
+				Expect(dep).To(Equal(libbuildpack.Dependency{Name: "thing", Version: "1.3.0"}))
+
+				Expect(filepath.Join(outputDir, "thing", "bin", "file2.exe")).To(BeAnExistingFile())
+				Expect(buffer.String()).NotTo(ContainSubstring("instead of"))
+			})
+		})
+
+		Context("the highest matching versions fail to fetch or verify", func() {
+			BeforeEach(func() {
+				httpmock.RegisterResponder("GET", "https://example.com/dependencies/thing-1.3.0.tgz",
+					httpmock.NewStringResponder(404, "not found"))
+				httpmock.RegisterResponder("GET", "https://example.com/dependencies/thing-1.2.4.tgz",
+					httpmock.NewStringResponder(200, "corrupt"))
+			})
+
+			It("installs the next matching version", func() {
+				dep, err := installer.InstallMatching("thing", "1.x", outputDir)
+				Expect(err).To(BeNil())
+				Expect(dep).To(Equal(libbuildpack.Dependency{Name: "thing", Version: "1.2.3"}))
+
+				Expect(filepath.Join(outputDir, "thing", "bin", "file2.exe")).To(BeAnExistingFile())
+			})
+
+			It("logs which version was substituted and why", func() {
+				_, err := installer.InstallMatching("thing", "1.x", outputDir)
+				Expect(err).To(BeNil())
+
+				Expect(buffer.String()).To(ContainSubstring("Could not fetch thing 1.3.0: could not download: 404"))
+				Expect(buffer.String()).To(ContainSubstring("Could not fetch thing 1.2.4: dependency sha256 mismatch"))
+				Expect(buffer.String()).To(ContainSubstring("Installing thing 1.2.3 instead of 1.3.0"))
+			})
+		})
+
+		Context("no matching version can be fetched", func() {
+			BeforeEach(func() {
+				for _, version := range []string{"1.2.3", "1.2.4", "1.3.0"} {
+					httpmock.RegisterResponder("GET", "https://example.com/dependencies/thing-"+version+".tgz",
+						httpmock.NewStringResponder(404, "not found"))
+				}
+			})
+
+			It("returns an error naming every attempt", func() {
+				_, err := installer.InstallMatching("thing", "1.x", outputDir)
+				Expect(err).To(MatchError(ContainSubstring("could not fetch any version of thing matching 1.x")))
+				Expect(err.Error()).To(ContainSubstring("1.3.0: could not download: 404"))
+				Expect(err.Error()).To(ContainSubstring("1.2.3: could not download: 404"))
+			})
+		})
+
+		Context("no version matches the constraint", func() {
+			It("returns an error", func() {
+				_, err := installer.InstallMatching("thing", "3.x", outputDir)
+				Expect(err).To(MatchError(ContainSubstring("no match found for 3.x")))
+			})
+		})
+	})
+
+	Describe("InstallVersions", func() {
+		var baseDir string
+
+		BeforeEach(func() {
+			baseDir, err = os.MkdirTemp("", "deps")
+			Expect(err).To(BeNil())
+			DeferCleanup(os.RemoveAll, baseDir)
+
+			manifestDir, err = os.MkdirTemp("", "buildpack")
+			Expect(err).To(BeNil())
+			DeferCleanup(os.RemoveAll, manifestDir)
+
+			tgzContents, err := os.ReadFile("fixtures/thing.tgz")
+			Expect(err).To(BeNil())
+
+			var entries []libbuildpack.ManifestEntry
+			for _, version := range []string{"8.0.1", "8.0.2", "11.0.5", "17.0.1"} {
+				uri := "https://example.com/dependencies/jdk-" + version + ".tgz"
+				entries = append(entries, libbuildpack.ManifestEntry{
+					Dependency: libbuildpack.Dependency{Name: "jdk", Version: version},
+					URI:        uri,
+					SHA256:     "8208480eb849203632239f73bd3c61ed488546d19d29c06d7c2e1649d8950bd1",
+					CFStacks:   []string{"cflinuxfs2"},
+				})
+				httpmock.RegisterResponder("GET", uri, httpmock.NewStringResponder(200, string(tgzContents)))
+			}
+			y := libbuildpack.NewYAML()
+			Expect(y.Write(filepath.Join(manifestDir, "manifest.yml"), libbuildpack.Manifest{LanguageString: "sample", ManifestEntries: entries})).To(Succeed())
+		})
+
+		It("installs each matching version side by side", func() {
+			paths, err := installer.InstallVersions("jdk", []string{"17.x", "8.x"}, baseDir)
+			Expect(err).To(BeNil())
+			Expect(paths).To(Equal(map[string]string{
+				"17.0.1": filepath.Join(baseDir, "jdk", "17.0.1"),
+				"8.0.2":  filepath.Join(baseDir, "jdk", "8.0.2"),
+			}))
+
+			Expect(filepath.Join(baseDir, "jdk", "17.0.1", "thing", "bin", "file2.exe")).To(BeAnExistingFile())
+			Expect(filepath.Join(baseDir, "jdk", "8.0.2", "thing", "bin", "file2.exe")).To(BeAnExistingFile())
+		})
+
+		It("links the version of the first constraint as the default", func() {
+			_, err := installer.InstallVersions("jdk", []string{"8.x", "17.x"}, baseDir)
+			Expect(err).To(BeNil())
+
+			if runtime.GOOS != "windows" {
+				Expect(os.Readlink(filepath.Join(baseDir, "jdk", "default"))).To(Equal("8.0.2"))
+			}
+			Expect(buffer.String()).To(ContainSubstring("Using jdk 8.0.2 as the default"))
+		})
+
+		It("records the default version in a file", func() {
+			_, err := installer.InstallVersions("jdk", []string{"8.x", "17.x"}, baseDir)
+			Expect(err).To(BeNil())
+
+			Expect(libbuildpack.InstalledDefaultVersion(baseDir, "jdk")).To(Equal("8.0.2"))
+		})
+
+		It("installs a version once when several constraints match it", func() {
+			paths, err := installer.InstallVersions("jdk", []string{"11.x", "~11.0"}, baseDir)
+			Expect(err).To(BeNil())
+			Expect(paths).To(HaveLen(1))
+			Expect(paths).To(HaveKey("11.0.5"))
+		})
+
+		It("replaces a previously recorded default", func() {
+			_, err := installer.InstallVersions("jdk", []string{"8.x"}, baseDir)
+			Expect(err).To(BeNil())
+			_, err = installer.InstallVersions("jdk", []string{"17.x"}, baseDir)
+			Expect(err).To(BeNil())
+
+			Expect(libbuildpack.InstalledDefaultVersion(baseDir, "jdk")).To(Equal("17.0.1"))
+			if runtime.GOOS != "windows" {
+				Expect(os.Readlink(filepath.Join(baseDir, "jdk", "default"))).To(Equal("17.0.1"))
+			}
+		})
+
+		It("fails when a constraint matches no version", func() {
+			_, err := installer.InstallVersions("jdk", []string{"17.x", "21.x"}, baseDir)
+			Expect(err).To(MatchError(ContainSubstring("no match found for 21.x")))
+		})
+
+		It("fails when no constraints are given", func() {
+			_, err := installer.InstallVersions("jdk", nil, baseDir)
+			Expect(err).To(MatchError("no versions of jdk requested"))
+		})
+	})
+
 	Describe("SetVersionLine", func() {
 		var i *libbuildpack.Installer
 		var versionLine map[string]string
@@ -1,65 +1,427 @@
 package libbuildpack
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+)
+
+// Env vars that control Logger output. LogFormatEnv selects the format of
+// NewLogger: "json" for NewJSONLogger, anything else for text.
+//
+// LogLevelEnv is the lowest level that is logged: trace, debug, info, warn or
+// error. It defaults to debug if BP_DEBUG is set, and info otherwise.
+//
+// LogColorEnv is always, never, or auto to color only terminals. It defaults
+// to always, because staging output reaches the app logs through a pipe.
+// NO_COLOR turns colors off regardless. LogColorSchemeEnv set to yellow-warnings shows
+// warnings in yellow instead of red.
+const (
+	LogFormatEnv      = "BP_LOG_FORMAT"
+	LogLevelEnv       = "BP_LOG_LEVEL"
+	LogColorEnv       = "BP_LOG_COLOR"
+	LogColorSchemeEnv = "BP_LOG_COLOR_SCHEME"
+)
+
+type LogLevel int
+
+const (
+	LogTrace LogLevel = iota
+	LogDebug
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = []string{"trace", "debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if l < LogTrace || l > LogError {
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+// ParseLogLevel parses the name of a level, ignoring case. "warning" is the
+// same as "warn".
+func ParseLogLevel(name string) (LogLevel, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "warning" {
+		return LogWarn, nil
+	}
+	for level, levelName := range logLevelNames {
+		if name == levelName {
+			return LogLevel(level), nil
+		}
+	}
+	return LogInfo, fmt.Errorf("invalid log level %q: must be one of %s", name, strings.Join(logLevelNames, ", "))
+}
+
+// ColorScheme holds the ANSI color codes of the labels of errors, warnings,
+// and of pro tips and debug messages.
+type ColorScheme struct {
+	Error   string
+	Warning string
+	Note    string
+}
+
+var (
+	DefaultColorScheme       = ColorScheme{Error: redPrefix, Warning: redPrefix, Note: bluePrefix}
+	YellowWarningColorScheme = ColorScheme{Error: redPrefix, Warning: yellowPrefix, Note: bluePrefix}
 )
 
 type Logger struct {
-	w io.Writer
+	w      io.Writer
+	json   bool
+	fields map[string]interface{}
+	state  *loggerState
+	output *lineLogger
+}
+
+// loggerState is shared by a Logger and the loggers derived from it with
+// WithFields.
+type loggerState struct {
+	lock     sync.Mutex
+	warnings []string
+	steps    []string
+	level    *LogLevel
+	color    *bool
+	scheme   *ColorScheme
+	redactor *Redactor
+}
+
+// logEvent is a line of NewJSONLogger output. Step holds the names of the
+// steps the event happened in, outermost first.
+type logEvent struct {
+	Time    string                 `json:"time"`
+	Level   string                 `json:"level"`
+	Event   string                 `json:"event,omitempty"`
+	Message string                 `json:"message"`
+	Step    []string               `json:"step,omitempty"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
 }
 
 const (
-	msgPrefix   = "       "
-	redPrefix   = "\033[31;1m"
-	bluePrefix  = "\033[34;1m"
-	colorSuffix = "\033[0m"
-	msgError    = msgPrefix + redPrefix + "**ERROR**" + colorSuffix
-	msgWarning  = msgPrefix + redPrefix + "**WARNING**" + colorSuffix
-	msgProtip   = msgPrefix + bluePrefix + "PRO TIP:" + colorSuffix
-	msgDebug    = msgPrefix + bluePrefix + "DEBUG:" + colorSuffix
+	msgPrefix    = "       "
+	redPrefix    = "\033[31;1m"
+	yellowPrefix = "\033[33;1m"
+	bluePrefix   = "\033[34;1m"
+	colorSuffix  = "\033[0m"
 )
 
+// NewLogger returns a Logger that writes colored text to w, or JSON if
+// LogFormatEnv is set to json.
 func NewLogger(w io.Writer) *Logger {
-	return &Logger{w: w}
+	if os.Getenv(LogFormatEnv) == "json" {
+		return NewJSONLogger(w)
+	}
+	return &Logger{w: w, state: &loggerState{}}
+}
+
+// NewJSONLogger returns a Logger that writes one JSON object per line to w
+// for every message, with its time, level, message, steps and fields.
+func NewJSONLogger(w io.Writer) *Logger {
+	return &Logger{w: w, json: true, state: &loggerState{}}
+}
+
+// WithFields returns a Logger that adds fields to every message it logs.
+// Text output lists them after the message as key=value.
+func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
+	combined := make(map[string]interface{}, len(l.fields)+len(fields))
+	for key, value := range l.fields {
+		combined[key] = value
+	}
+	for key, value := range fields {
+		combined[key] = value
+	}
+	return &Logger{w: l.w, json: l.json, fields: combined, state: l.state}
+}
+
+// SetLevel sets the lowest level that is logged, in place of LogLevelEnv.
+func (l *Logger) SetLevel(level LogLevel) {
+	l.state.lock.Lock()
+	defer l.state.lock.Unlock()
+	l.state.level = &level
+}
+
+// Level returns the lowest level that is logged.
+func (l *Logger) Level() LogLevel {
+	l.state.lock.Lock()
+	defer l.state.lock.Unlock()
+
+	if l.state.level != nil {
+		return *l.state.level
+	}
+	if level, err := ParseLogLevel(os.Getenv(LogLevelEnv)); err == nil {
+		return level
+	}
+	if os.Getenv("BP_DEBUG") != "" {
+		return LogDebug
+	}
+	return LogInfo
+}
+
+// SetColor turns colors on or off, in place of LogColorEnv and NO_COLOR.
+func (l *Logger) SetColor(color bool) {
+	l.state.lock.Lock()
+	defer l.state.lock.Unlock()
+	l.state.color = &color
+}
+
+// SetColorScheme sets the colors of labels, in place of LogColorSchemeEnv.
+func (l *Logger) SetColorScheme(scheme ColorScheme) {
+	l.state.lock.Lock()
+	defer l.state.lock.Unlock()
+	l.state.scheme = &scheme
+}
+
+// SetRedactor sets the redactor that masks secrets in messages, in place of
+// the package wide one that AddSecret registers secrets with.
+func (l *Logger) SetRedactor(redactor *Redactor) {
+	l.state.lock.Lock()
+	defer l.state.lock.Unlock()
+	l.state.redactor = redactor
+}
+
+// Redactor returns the redactor that masks secrets in messages.
+func (l *Logger) Redactor() *Redactor {
+	l.state.lock.Lock()
+	defer l.state.lock.Unlock()
+
+	if l.state.redactor != nil {
+		return l.state.redactor
+	}
+	return globalRedactor
+}
+
+func (l *Logger) Trace(format string, args ...interface{}) {
+	l.log(LogTrace, "", l.label(l.colorScheme().Note, "TRACE:"), format, args...)
 }
 
 func (l *Logger) Info(format string, args ...interface{}) {
-	l.printWithHeader("      ", format, args...)
+	l.log(LogInfo, "", "      ", format, args...)
 }
 
+// Warning logs a warning if the level allows it, and records it for
+// Warnings either way.
 func (l *Logger) Warning(format string, args ...interface{}) {
-	l.printWithHeader(msgWarning, format, args...)
+	warning := l.Redactor().Redact(fmt.Sprintf(format, args...))
+	l.state.lock.Lock()
+	l.state.warnings = append(l.state.warnings, warning)
+	l.state.lock.Unlock()
+	l.log(LogWarn, "", l.label(l.colorScheme().Warning, "**WARNING**"), format, args...)
+}
 
+// Warnings returns the messages logged with Warning so far.
+func (l *Logger) Warnings() []string {
+	l.state.lock.Lock()
+	defer l.state.lock.Unlock()
+	return l.state.warnings
 }
+
 func (l *Logger) Error(format string, args ...interface{}) {
-	l.printWithHeader(msgError, format, args...)
+	l.log(LogError, "", l.label(l.colorScheme().Error, "**ERROR**"), format, args...)
 }
 
 func (l *Logger) Debug(format string, args ...interface{}) {
-	if os.Getenv("BP_DEBUG") != "" {
-		l.printWithHeader(msgDebug, format, args...)
-	}
+	l.log(LogDebug, "", l.label(l.colorScheme().Note, "DEBUG:"), format, args...)
 }
 
 func (l *Logger) BeginStep(format string, args ...interface{}) {
-	l.printWithHeader("----->", format, args...)
+	l.beginStep(0, fmt.Sprintf(format, args...))
 }
 
 func (l *Logger) Protip(tip string, helpURL string) {
-	l.printWithHeader(msgProtip, "%s", tip)
+	if l.Level() > LogInfo {
+		return
+	}
+	if l.json {
+		l.WithFields(map[string]interface{}{"url": helpURL}).writeEvent(LogInfo, "protip", tip)
+		return
+	}
+	l.printWithHeader(l.label(l.colorScheme().Note, "PRO TIP:"), "%s", tip)
 	l.printWithHeader(msgPrefix+"Visit", "%s", helpURL)
 }
 
+// Output returns a writer to the logger's output that masks secrets. For JSON
+// loggers, every line written to it is logged as an info message instead.
+// Every call then returns the same writer; see Flush.
+func (l *Logger) Output() io.Writer {
+	if !l.json {
+		return &redactWriter{w: l.w, redactor: l.Redactor()}
+	}
+
+	l.state.lock.Lock()
+	defer l.state.lock.Unlock()
+	if l.output == nil {
+		l.output = newLineLogger(l)
+	}
+	return l.output
+}
+
+// Flush logs what was written to the Output of a JSON logger after its last
+// newline. SupplyMain and FinalizeMain flush their logger when the phase ends.
+func (l *Logger) Flush() error {
+	l.state.lock.Lock()
+	output := l.output
+	l.state.lock.Unlock()
+
+	if output == nil {
+		return nil
+	}
+	return output.Close()
+}
+
+// beginStep starts the step name, nested in depth outer steps. Text output
+// shows top level steps with an arrow and indents nested ones.
+func (l *Logger) beginStep(depth int, name string) {
+	l.state.lock.Lock()
+	if depth > len(l.state.steps) {
+		depth = len(l.state.steps)
+	}
+	l.state.steps = append(l.state.steps[:depth], name)
+	l.state.lock.Unlock()
+
+	switch {
+	case l.Level() > LogInfo:
+	case l.json:
+		l.writeEvent(LogInfo, "step", name)
+	case depth == 0:
+		l.printWithHeader("----->", "%s", name)
+	default:
+		l.printWithHeader("      ", "%s%s", strings.Repeat("  ", depth-1), name)
+	}
+}
+
+// endStep ends the steps nested in depth outer steps.
+func (l *Logger) endStep(depth int) {
+	l.state.lock.Lock()
+	defer l.state.lock.Unlock()
+	if depth < len(l.state.steps) {
+		l.state.steps = l.state.steps[:depth]
+	}
+}
+
+func (l *Logger) log(level LogLevel, event, header, format string, args ...interface{}) {
+	if level < l.Level() {
+		return
+	}
+	if l.json {
+		l.writeEvent(level, event, fmt.Sprintf(format, args...))
+		return
+	}
+	l.printWithHeader(header, format, args...)
+}
+
+func (l *Logger) writeEvent(logLevel LogLevel, event, msg string) {
+	level := logLevel.String()
+
+	redactor := l.Redactor()
+	msg = redactor.Redact(msg)
+	fields := make(map[string]interface{}, len(l.fields))
+	for key, value := range l.fields {
+		if text, ok := value.(string); ok {
+			value = redactor.Redact(text)
+		}
+		fields[key] = value
+	}
+
+	l.state.lock.Lock()
+	defer l.state.lock.Unlock()
+
+	data, err := json.Marshal(logEvent{
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Level:   level,
+		Event:   event,
+		Message: msg,
+		Step:    l.state.steps,
+		Fields:  fields,
+	})
+	if err != nil {
+		data, _ = json.Marshal(logEvent{Time: time.Now().UTC().Format(time.RFC3339Nano), Level: level, Event: event, Message: msg, Step: l.state.steps})
+	}
+	fmt.Fprintf(l.w, "%s\n", data)
+}
+
 func (l *Logger) printWithHeader(header string, format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
+	msg = l.Redactor().Redact(msg + l.textFields())
 
 	msg = strings.Replace(msg, "\n", "\n       ", -1)
 	fmt.Fprintf(l.w, "%s %s\n", header, msg)
 }
 
-func (l *Logger) Output() io.Writer {
-	return l.w
+// label returns a message label, in color unless colors are off.
+func (l *Logger) label(color, text string) string {
+	if !l.colored() {
+		return msgPrefix + text
+	}
+	return msgPrefix + color + text + colorSuffix
+}
+
+func (l *Logger) colored() bool {
+	l.state.lock.Lock()
+	color := l.state.color
+	l.state.lock.Unlock()
+
+	switch {
+	case color != nil:
+		return *color
+	case os.Getenv("NO_COLOR") != "":
+		return false
+	}
+
+	switch os.Getenv(LogColorEnv) {
+	case "never":
+		return false
+	case "auto":
+		return isTerminal(l.w)
+	default:
+		return true
+	}
+}
+
+func (l *Logger) colorScheme() ColorScheme {
+	l.state.lock.Lock()
+	defer l.state.lock.Unlock()
+
+	if l.state.scheme != nil {
+		return *l.state.scheme
+	}
+	if os.Getenv(LogColorSchemeEnv) == "yellow-warnings" {
+		return YellowWarningColorScheme
+	}
+	return DefaultColorScheme
+}
+
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (l *Logger) textFields() string {
+	if len(l.fields) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(l.fields))
+	for key := range l.fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var fields strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&fields, " %s=%v", key, l.fields[key])
+	}
+	return fields.String()
 }
@@ -2,7 +2,11 @@ package libbuildpack_test
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/cloudfoundry/libbuildpack"
 	. "github.com/onsi/ginkgo/v2"
@@ -57,4 +61,196 @@ var _ = Describe("Logger", func() {
 			})
 		})
 	})
+
+	Describe("levels", func() {
+		BeforeEach(func() {
+			for _, name := range []string{libbuildpack.LogLevelEnv, "BP_DEBUG"} {
+				DeferCleanup(os.Setenv, name, os.Getenv(name))
+				os.Unsetenv(name)
+			}
+		})
+
+		It("logs info and above by default", func() {
+			logger.Trace("trace")
+			logger.Debug("debug")
+			logger.Info("info")
+			logger.Error("error")
+			Expect(buffer.String()).To(Equal("       info\n       \033[31;1m**ERROR**\033[0m error\n"))
+		})
+
+		It("is set by BP_LOG_LEVEL", func() {
+			os.Setenv(libbuildpack.LogLevelEnv, "trace")
+			logger.Trace("traced")
+			Expect(buffer.String()).To(Equal("       \033[34;1mTRACE:\033[0m traced\n"))
+		})
+
+		It("keeps recording warnings that are not logged", func() {
+			os.Setenv(libbuildpack.LogLevelEnv, "ERROR")
+			logger.BeginStep("step")
+			logger.Protip("tip", "https://example.com")
+			logger.Warning("careful")
+			Expect(buffer.String()).To(BeEmpty())
+			Expect(logger.Warnings()).To(Equal([]string{"careful"}))
+		})
+
+		It("prefers SetLevel to the environment", func() {
+			os.Setenv("BP_DEBUG", "true")
+			logger.SetLevel(libbuildpack.LogWarn)
+			logger.Debug("debug")
+			logger.Info("info")
+			logger.WithFields(map[string]interface{}{"a": 1}).Info("info")
+			Expect(buffer.String()).To(BeEmpty())
+			Expect(logger.Level()).To(Equal(libbuildpack.LogWarn))
+		})
+
+		It("parses level names", func() {
+			Expect(libbuildpack.ParseLogLevel("Warning")).To(Equal(libbuildpack.LogWarn))
+			Expect(libbuildpack.LogDebug.String()).To(Equal("debug"))
+
+			_, err := libbuildpack.ParseLogLevel("loud")
+			Expect(err).To(MatchError(`invalid log level "loud": must be one of trace, debug, info, warn, error`))
+		})
+	})
+
+	Describe("colors", func() {
+		BeforeEach(func() {
+			for _, name := range []string{"NO_COLOR", libbuildpack.LogColorEnv, libbuildpack.LogColorSchemeEnv} {
+				DeferCleanup(os.Setenv, name, os.Getenv(name))
+				os.Unsetenv(name)
+			}
+		})
+
+		It("colors labels by default", func() {
+			logger.Warning("careful")
+			Expect(buffer.String()).To(Equal("       \033[31;1m**WARNING**\033[0m careful\n"))
+		})
+
+		It("honors NO_COLOR", func() {
+			os.Setenv("NO_COLOR", "1")
+			logger.Warning("careful")
+			logger.Protip("tip", "https://example.com")
+			Expect(buffer.String()).To(Equal("       **WARNING** careful\n       PRO TIP: tip\n       Visit https://example.com\n"))
+		})
+
+		It("colors only terminals in auto mode", func() {
+			os.Setenv(libbuildpack.LogColorEnv, "auto")
+			logger.Error("broken")
+			Expect(buffer.String()).To(Equal("       **ERROR** broken\n"))
+		})
+
+		It("shows warnings in yellow with the yellow-warnings scheme", func() {
+			os.Setenv(libbuildpack.LogColorSchemeEnv, "yellow-warnings")
+			logger.Warning("careful")
+			logger.Error("broken")
+			Expect(buffer.String()).To(Equal("       \033[33;1m**WARNING**\033[0m careful\n       \033[31;1m**ERROR**\033[0m broken\n"))
+		})
+
+		It("prefers SetColor and SetColorScheme to the environment", func() {
+			os.Setenv("NO_COLOR", "1")
+			logger.SetColor(true)
+			logger.SetColorScheme(libbuildpack.YellowWarningColorScheme)
+			logger.Warning("careful")
+			Expect(buffer.String()).To(Equal("       \033[33;1m**WARNING**\033[0m careful\n"))
+		})
+	})
+
+	Describe("WithFields", func() {
+		It("adds the fields to text messages", func() {
+			logger.WithFields(map[string]interface{}{"dependency": "node", "version": "6.9.4"}).Info("Installing")
+			Expect(buffer.String()).To(Equal("       Installing dependency=node version=6.9.4\n"))
+		})
+
+		It("shares warnings with the parent logger", func() {
+			logger.WithFields(map[string]interface{}{"a": 1}).Warning("careful")
+			Expect(logger.Warnings()).To(Equal([]string{"careful"}))
+		})
+	})
+
+	Describe("JSON output", func() {
+		var events func() []map[string]interface{}
+
+		BeforeEach(func() {
+			logger = libbuildpack.NewJSONLogger(buffer)
+			events = func() []map[string]interface{} {
+				var events []map[string]interface{}
+				for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+					var event map[string]interface{}
+					Expect(json.Unmarshal([]byte(line), &event)).To(Succeed(), line)
+					events = append(events, event)
+				}
+				return events
+			}
+		})
+
+		It("logs one object per message with its level and time", func() {
+			logger.Info("hello %s", "world")
+			logger.Warning("careful")
+			logger.Error("broken\nbadly")
+
+			Expect(events()).To(HaveLen(3))
+			Expect(events()[0]).To(HaveKeyWithValue("level", "info"))
+			Expect(events()[0]).To(HaveKeyWithValue("message", "hello world"))
+			Expect(events()[1]).To(HaveKeyWithValue("level", "warn"))
+			Expect(events()[2]).To(HaveKeyWithValue("message", "broken\nbadly"))
+
+			timestamp, err := time.Parse(time.RFC3339Nano, events()[0]["time"].(string))
+			Expect(err).To(BeNil())
+			Expect(timestamp).To(BeTemporally("~", time.Now(), time.Minute))
+			Expect(logger.Warnings()).To(Equal([]string{"careful"}))
+		})
+
+		It("records the current step", func() {
+			logger.Info("before")
+			logger.BeginStep("Installing %s", "node")
+			logger.Info("downloading")
+
+			Expect(events()[0]).NotTo(HaveKey("step"))
+			Expect(events()[1]).To(HaveKeyWithValue("event", "step"))
+			Expect(events()[1]).To(HaveKeyWithValue("step", []interface{}{"Installing node"}))
+			Expect(events()[2]).To(HaveKeyWithValue("step", []interface{}{"Installing node"}))
+		})
+
+		It("logs fields", func() {
+			logger.WithFields(map[string]interface{}{"dependency": "node"}).WithFields(map[string]interface{}{"attempt": 2}).Error("failed")
+
+			Expect(events()[0]).To(HaveKeyWithValue("fields", map[string]interface{}{"dependency": "node", "attempt": float64(2)}))
+			Expect(buffer.String()).NotTo(ContainSubstring("\033"))
+		})
+
+		It("logs protips with their URL", func() {
+			logger.Protip("Use a newer version", "https://example.com/help")
+
+			Expect(events()).To(HaveLen(1))
+			Expect(events()[0]).To(HaveKeyWithValue("event", "protip"))
+			Expect(events()[0]).To(HaveKeyWithValue("fields", map[string]interface{}{"url": "https://example.com/help"}))
+		})
+
+		It("logs lines written to Output as info messages", func() {
+			fmt.Fprint(logger.Output(), "line one\nline two\n")
+
+			Expect(events()).To(HaveLen(2))
+			Expect(events()[1]).To(HaveKeyWithValue("message", "line two"))
+		})
+
+		It("logs a line split across writes to Output once flushed", func() {
+			fmt.Fprint(logger.Output(), "line ")
+			fmt.Fprint(logger.Output(), "one\nline two")
+			Expect(events()).To(HaveLen(1))
+			Expect(events()[0]).To(HaveKeyWithValue("message", "line one"))
+
+			Expect(logger.Flush()).To(Succeed())
+			Expect(events()).To(HaveLen(2))
+			Expect(events()[1]).To(HaveKeyWithValue("message", "line two"))
+		})
+
+		It("is selected with BP_LOG_FORMAT", func() {
+			DeferCleanup(os.Setenv, libbuildpack.LogFormatEnv, os.Getenv(libbuildpack.LogFormatEnv))
+			os.Setenv(libbuildpack.LogFormatEnv, "json")
+
+			buffer.Reset()
+			libbuildpack.NewLogger(buffer).Debug("hidden")
+			libbuildpack.NewLogger(buffer).Info("shown")
+			Expect(events()[0]).To(HaveKeyWithValue("message", "shown"))
+		})
+	})
 })
@@ -1,6 +1,7 @@
 package libbuildpack
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -32,11 +33,13 @@ type DeprecationDate struct {
 }
 
 type ManifestEntry struct {
-	Dependency Dependency `yaml:",inline"`
-	URI        string     `yaml:"uri"`
-	File       string     `yaml:"file"`
-	SHA256     string     `yaml:"sha256"`
-	CFStacks   []string   `yaml:"cf_stacks"`
+	Dependency      Dependency      `yaml:",inline"`
+	URI             string          `yaml:"uri"`
+	File            string          `yaml:"file"`
+	SHA256          string          `yaml:"sha256"`
+	CFStacks        []string        `yaml:"cf_stacks"`
+	Relocation      *Relocation     `yaml:"relocation,omitempty"`
+	SubDependencies []SubDependency `yaml:"dependencies,omitempty"`
 }
 
 type Manifest struct {
@@ -48,11 +51,14 @@ type Manifest struct {
 	manifestRootDir string
 	currentTime     time.Time //move into installer?
 	log             *Logger
+	installed       []DependencyFingerprint
 }
 
 type BuildpackMetadata struct {
-	Language string `yaml:"language"`
-	Version  string `yaml:"version"`
+	Language     string                  `yaml:"language"`
+	Version      string                  `yaml:"version"`
+	Stack        string                  `yaml:"stack,omitempty"`
+	Dependencies []DependencyFingerprint `yaml:"dependencies,omitempty"`
 }
 
 func NewManifest(bpDir string, logger *Logger, currentTime time.Time) (*Manifest, error) {
@@ -161,7 +167,12 @@ func (m *Manifest) StoreBuildpackMetadata(cacheDir string) error {
 		return err
 	}
 
-	md := BuildpackMetadata{Language: m.Language(), Version: version}
+	md := BuildpackMetadata{
+		Language:     m.Language(),
+		Version:      version,
+		Stack:        os.Getenv("CF_STACK"),
+		Dependencies: m.installed,
+	}
 
 	if exists, err := FileExists(cacheDir); err != nil {
 		return err
@@ -169,6 +180,9 @@ func (m *Manifest) StoreBuildpackMetadata(cacheDir string) error {
 		return nil
 	}
 
+	if Planning() {
+		return m.planWriteYAML(filepath.Join(cacheDir, "BUILDPACK_METADATA"), &md)
+	}
 	y := &YAML{}
 	return y.Write(filepath.Join(cacheDir, "BUILDPACK_METADATA"), &md)
 }
@@ -276,13 +290,13 @@ func deleteBadFile(entry *ManifestEntry, outputFile string) error {
 	return nil
 }
 
-func downloadDependency(entry *ManifestEntry, outputFile string, logger *Logger, retryTimeLimit time.Duration, retryTimeInitialInterval time.Duration) error {
+func downloadDependency(ctx context.Context, entry *ManifestEntry, outputFile string, logger *Logger, retryTimeLimit time.Duration, retryTimeInitialInterval time.Duration) error {
 	filteredURI, err := filterURI(entry.URI)
 	if err != nil {
 		return err
 	}
 	logger.Info("Download [%s]", filteredURI)
-	err = downloadFile(entry.URI, outputFile, retryTimeLimit, retryTimeInitialInterval, logger)
+	err = downloadFile(ctx, entry.URI, outputFile, retryTimeLimit, retryTimeInitialInterval, logger)
 	if err != nil {
 		return err
 	}
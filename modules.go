@@ -0,0 +1,98 @@
+package libbuildpack
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SubDependency is a module packaged inside a dependency, such as a PHP
+// extension or an nginx module.
+type SubDependency struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version,omitempty"`
+}
+
+// ModuleEnabler turns on a single module inside an installed dependency,
+// for example by adding an extension line to a config file.
+type ModuleEnabler func(module SubDependency, installDir string) error
+
+// AvailableModules lists the sub-dependencies the manifest declares for dep,
+// sorted by name.
+func (i *Installer) AvailableModules(dep Dependency) ([]SubDependency, error) {
+	entry, err := i.manifest.GetEntry(dep)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := append([]SubDependency{}, entry.SubDependencies...)
+	sort.Slice(modules, func(a, b int) bool { return modules[a].Name < modules[b].Name })
+
+	return modules, nil
+}
+
+// ValidateModules checks that every requested module name is provided by dep,
+// logging the supported modules if any are not.
+func (i *Installer) ValidateModules(dep Dependency, requested []string) error {
+	_, err := i.resolveModules(dep, requested)
+	return err
+}
+
+// EnableModules validates the requested modules and calls enable for each of
+// them, in the order they were requested.
+func (i *Installer) EnableModules(dep Dependency, installDir string, requested []string, enable ModuleEnabler) error {
+	modules, err := i.resolveModules(dep, requested)
+	if err != nil {
+		return err
+	}
+
+	for _, module := range modules {
+		if module.Version != "" {
+			i.manifest.log.Info("Enabling %s module %s %s", dep.Name, module.Name, module.Version)
+		} else {
+			i.manifest.log.Info("Enabling %s module %s", dep.Name, module.Name)
+		}
+
+		if err := enable(module, installDir); err != nil {
+			return fmt.Errorf("could not enable %s module %s: %v", dep.Name, module.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (i *Installer) resolveModules(dep Dependency, requested []string) ([]SubDependency, error) {
+	available, err := i.AvailableModules(dep)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]SubDependency{}
+	for _, module := range available {
+		byName[module.Name] = module
+	}
+
+	var modules []SubDependency
+	var unknown []string
+	seen := map[string]bool{}
+	for _, name := range requested {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if module, found := byName[name]; found {
+			modules = append(modules, module)
+		} else {
+			unknown = append(unknown, name)
+		}
+	}
+
+	if len(unknown) > 0 {
+		i.manifest.log.Error(modulesMissingError(dep, unknown, available))
+		return nil, fmt.Errorf("modules not provided by %s %s: %s", dep.Name, dep.Version, strings.Join(unknown, ", "))
+	}
+
+	return modules, nil
+}
@@ -0,0 +1,135 @@
+package libbuildpack_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Modules", func() {
+	var (
+		oldCfStack  string
+		installer   *libbuildpack.Installer
+		manifestDir string
+		buffer      *bytes.Buffer
+		php         libbuildpack.Dependency
+		err         error
+	)
+
+	BeforeEach(func() {
+		oldCfStack = os.Getenv("CF_STACK")
+		os.Setenv("CF_STACK", "cflinuxfs3")
+		DeferCleanup(os.Setenv, "CF_STACK", oldCfStack)
+
+		manifestDir, err = os.MkdirTemp("", "buildpack")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, manifestDir)
+
+		php = libbuildpack.Dependency{Name: "php", Version: "8.1.0"}
+		manifestForTest := libbuildpack.Manifest{
+			LanguageString: "php",
+			ManifestEntries: []libbuildpack.ManifestEntry{
+				{
+					Dependency: php,
+					CFStacks:   []string{"cflinuxfs3"},
+					SubDependencies: []libbuildpack.SubDependency{
+						{Name: "redis", Version: "5.3.4"},
+						{Name: "apcu", Version: "5.1.21"},
+						{Name: "pdo_mysql"},
+					},
+				},
+				{
+					Dependency: libbuildpack.Dependency{Name: "composer", Version: "2.2.0"},
+					CFStacks:   []string{"cflinuxfs3"},
+				},
+			},
+		}
+		Expect(libbuildpack.NewYAML().Write(filepath.Join(manifestDir, "manifest.yml"), manifestForTest)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		manifest, err := libbuildpack.NewManifest(manifestDir, libbuildpack.NewLogger(ansicleaner.New(buffer)), time.Now())
+		Expect(err).To(BeNil())
+		installer = libbuildpack.NewInstaller(manifest)
+	})
+
+	Describe("AvailableModules", func() {
+		It("returns the modules sorted by name", func() {
+			modules, err := installer.AvailableModules(php)
+			Expect(err).To(BeNil())
+			Expect(modules).To(Equal([]libbuildpack.SubDependency{
+				{Name: "apcu", Version: "5.1.21"},
+				{Name: "pdo_mysql"},
+				{Name: "redis", Version: "5.3.4"},
+			}))
+		})
+
+		It("returns an error for a dependency not in the manifest", func() {
+			_, err := installer.AvailableModules(libbuildpack.Dependency{Name: "php", Version: "7.4.0"})
+			Expect(err).To(MatchError("dependency php 7.4.0 not found"))
+		})
+	})
+
+	Describe("ValidateModules", func() {
+		It("accepts modules provided by the dependency", func() {
+			Expect(installer.ValidateModules(php, []string{"redis", "apcu"})).To(Succeed())
+		})
+
+		It("rejects unknown modules and lists the supported ones", func() {
+			err := installer.ValidateModules(php, []string{"redis", "pdo-mysql", "xdebug"})
+			Expect(err).To(MatchError("modules not provided by php 8.1.0: pdo-mysql, xdebug"))
+
+			Expect(buffer.String()).To(ContainSubstring("- pdo-mysql (did you mean pdo_mysql?)"))
+			Expect(buffer.String()).To(ContainSubstring("- xdebug\n"))
+			Expect(buffer.String()).To(ContainSubstring("- apcu 5.1.21"))
+		})
+
+		It("explains when the dependency has no modules", func() {
+			err := installer.ValidateModules(libbuildpack.Dependency{Name: "composer", Version: "2.2.0"}, []string{"redis"})
+			Expect(err).To(HaveOccurred())
+			Expect(buffer.String()).To(ContainSubstring("Dependency composer 2.2.0 does not provide any modules"))
+		})
+	})
+
+	Describe("EnableModules", func() {
+		var enabled []libbuildpack.SubDependency
+
+		enable := func(module libbuildpack.SubDependency, installDir string) error {
+			Expect(installDir).To(Equal("/tmp/php"))
+			enabled = append(enabled, module)
+			return nil
+		}
+
+		BeforeEach(func() {
+			enabled = nil
+		})
+
+		It("enables each requested module once, in order", func() {
+			Expect(installer.EnableModules(php, "/tmp/php", []string{"redis", "pdo_mysql", "redis"}, enable)).To(Succeed())
+			Expect(enabled).To(Equal([]libbuildpack.SubDependency{
+				{Name: "redis", Version: "5.3.4"},
+				{Name: "pdo_mysql"},
+			}))
+			Expect(buffer.String()).To(ContainSubstring("Enabling php module redis 5.3.4"))
+		})
+
+		It("enables nothing if any module is unknown", func() {
+			Expect(installer.EnableModules(php, "/tmp/php", []string{"redis", "xdebug"}, enable)).NotTo(Succeed())
+			Expect(enabled).To(BeEmpty())
+		})
+
+		It("wraps errors from the enabler", func() {
+			err := installer.EnableModules(php, "/tmp/php", []string{"apcu"}, func(libbuildpack.SubDependency, string) error {
+				return errors.New("no ini file")
+			})
+			Expect(err).To(MatchError("could not enable php module apcu: no ini file"))
+		})
+	})
+})
@@ -0,0 +1,356 @@
+package packager
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// CompileExtensionManifest is the manifest.yml layout of buildpacks built on
+// compile-extensions. Unlike Manifest, files are packaged unless they match
+// exclude_files, and deprecation dates may select versions by regex.
+type CompileExtensionManifest struct {
+	Language           string                            `yaml:"language"`
+	Stack              string                            `yaml:"stack"`
+	ExcludeFiles       []string                          `yaml:"exclude_files"`
+	UrlToDependencyMap []CompileExtensionURLMapping      `yaml:"url_to_dependency_map"`
+	DefaultVersions    []CompileExtensionDefaultVersion  `yaml:"default_versions"`
+	Dependencies       []CompileExtensionDependency      `yaml:"dependencies"`
+	DeprecationDates   []CompileExtensionDeprecationDate `yaml:"dependency_deprecation_dates"`
+}
+
+type CompileExtensionURLMapping struct {
+	Match   string `yaml:"match"`
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+}
+
+type CompileExtensionDefaultVersion struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+}
+
+type CompileExtensionDependency struct {
+	Name     string   `yaml:"name"`
+	Version  string   `yaml:"version"`
+	URI      string   `yaml:"uri"`
+	MD5      string   `yaml:"md5"`
+	SHA256   string   `yaml:"sha256"`
+	CFStacks []string `yaml:"cf_stacks"`
+	Modules  []string `yaml:"modules"`
+}
+
+type CompileExtensionDeprecationDate struct {
+	Name        string `yaml:"name"`
+	VersionLine string `yaml:"version_line"`
+	Date        string `yaml:"date"`
+	Link        string `yaml:"link"`
+	Match       string `yaml:"match"`
+}
+
+var translateURIRe = regexp.MustCompile(`[:/]`)
+
+// CompileExtensionPackage builds a cached or uncached zip of a
+// compile-extensions buildpack in bpDir. Zips of earlier runs are left out.
+// Cached dependencies are stored under dependencies/ with the translated
+// file names compile-extensions looks up at staging time. A stack of "any"
+// or "" packages dependencies for every stack.
+func CompileExtensionPackage(bpDir, version string, cached bool, stack string) (string, error) {
+	bpDir, err := filepath.Abs(bpDir)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get the absolute path of %s: %v", bpDir, err)
+	}
+	if stack == "any" {
+		stack = ""
+	}
+
+	manifest, err := readCompileExtensionManifest(bpDir)
+	if err != nil {
+		return "", fmt.Errorf("Failed to load manifest.yml: %v", err)
+	}
+	if err := manifest.validate(stack); err != nil {
+		return "", err
+	}
+
+	dir, err := CopyDirectory(bpDir)
+	if err != nil {
+		return "", fmt.Errorf("Failed to copy %s: %v", bpDir, err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.WriteFile(filepath.Join(dir, "VERSION"), []byte(version), 0644); err != nil {
+		return "", fmt.Errorf("Failed to write VERSION file: %v", err)
+	}
+
+	var m map[string]interface{}
+	if err := libbuildpack.NewYAML().Load(filepath.Join(dir, "manifest.yml"), &m); err != nil {
+		return "", err
+	}
+	deps, _ := m["dependencies"].([]interface{})
+
+	var files []File
+	dependenciesForStack := []interface{}{}
+	for idx, d := range manifest.Dependencies {
+		if !d.supportsStack(stack) {
+			continue
+		}
+
+		dependencyMap := deps[idx]
+		if stack != "" {
+			delete(dependencyMap.(map[interface{}]interface{}), "cf_stacks")
+		}
+		dependenciesForStack = append(dependenciesForStack, dependencyMap)
+
+		if cached {
+			file, err := downloadCompileExtensionDependency(d, CacheDir)
+			if err != nil {
+				return "", err
+			}
+			files = append(files, file)
+		}
+	}
+
+	if stack != "" {
+		m["stack"] = stack
+		m["dependencies"] = dependenciesForStack
+	}
+	if err := libbuildpack.NewYAML().Write(filepath.Join(dir, "manifest.yml"), m); err != nil {
+		return "", err
+	}
+
+	excludeFiles := append([]string{manifest.Language + "_buildpack*.zip"}, manifest.ExcludeFiles...)
+	bpFiles, err := compileExtensionFiles(dir, excludeFiles)
+	if err != nil {
+		return "", err
+	}
+	files = append(bpFiles, files...)
+
+	stackPart := ""
+	if stack != "" {
+		stackPart = "-" + stack
+	}
+	cachedPart := ""
+	if cached {
+		cachedPart = "-cached"
+	}
+
+	zipFile := filepath.Join(bpDir, fmt.Sprintf("%s_buildpack%s%s-v%s.zip", manifest.Language, cachedPart, stackPart, version))
+	if err := ZipFiles(zipFile, files); err != nil {
+		return "", err
+	}
+
+	return zipFile, nil
+}
+
+func readCompileExtensionManifest(bpDir string) (CompileExtensionManifest, error) {
+	var manifest CompileExtensionManifest
+	err := libbuildpack.NewYAML().Load(filepath.Join(bpDir, "manifest.yml"), &manifest)
+	return manifest, err
+}
+
+func (m CompileExtensionManifest) validate(stack string) error {
+	if m.Stack != "" {
+		return fmt.Errorf("Cannot package from already packaged buildpack manifest")
+	}
+
+	if stack != "" && len(m.Dependencies) > 0 {
+		found := false
+		for _, d := range m.Dependencies {
+			if d.supportsStack(stack) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Stack `%s` not found in manifest", stack)
+		}
+	}
+
+	for _, d := range m.DeprecationDates {
+		if _, err := time.Parse("2006-01-02", d.Date); err != nil {
+			return fmt.Errorf("Invalid deprecation date %s for %s %s: %v", d.Date, d.Name, d.VersionLine, err)
+		}
+		if d.Match != "" {
+			if _, err := regexp.Compile(d.Match); err != nil {
+				return fmt.Errorf("Invalid deprecation match %s for %s %s: %v", d.Match, d.Name, d.VersionLine, err)
+			}
+		}
+	}
+
+	for _, u := range m.UrlToDependencyMap {
+		if _, err := regexp.Compile(u.Match); err != nil {
+			return fmt.Errorf("Invalid url_to_dependency_map match %s for %s: %v", u.Match, u.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (d CompileExtensionDependency) supportsStack(stack string) bool {
+	if stack == "" {
+		return true
+	}
+	for _, s := range d.CFStacks {
+		if s == stack {
+			return true
+		}
+	}
+	return false
+}
+
+func downloadCompileExtensionDependency(dependency CompileExtensionDependency, cacheDir string) (File, error) {
+	file := filepath.Join("dependencies", translateURIRe.ReplaceAllString(dependency.URI, "_"))
+	cacheFile := filepath.Join(cacheDir, file)
+
+	check := func() error {
+		if dependency.SHA256 != "" {
+			return checkSha256(cacheFile, dependency.SHA256)
+		}
+		return checkMd5(cacheFile, dependency.MD5)
+	}
+
+	if _, err := os.Stat(cacheFile); err == nil {
+		if check() == nil {
+			return File{file, cacheFile}, nil
+		}
+		if err := os.Remove(cacheFile); err != nil {
+			return File{}, err
+		}
+	}
+
+	if err := DownloadFromURI(dependency.URI, cacheFile); err != nil {
+		return File{}, err
+	}
+	if err := check(); err != nil {
+		return File{}, fmt.Errorf("%s (%s %s): %v", dependency.URI, dependency.Name, dependency.Version, err)
+	}
+
+	return File{file, cacheFile}, nil
+}
+
+func checkMd5(filePath, expectedMd5 string) error {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	sum := md5.Sum(content)
+	actualMd5 := hex.EncodeToString(sum[:])
+
+	if actualMd5 != expectedMd5 {
+		return fmt.Errorf("dependency md5 mismatch: expected md5 %s, actual md5 %s", expectedMd5, actualMd5)
+	}
+	return nil
+}
+
+// compileExtensionFiles lists the files in dir, sorted by path, that are not
+// excluded. A pattern ending in / excludes a directory tree; other patterns
+// are globs matched against the relative path and the base name. Symlinks are
+// followed, as ZipFiles does for the files of Package.
+func compileExtensionFiles(dir string, excludeFiles []string) ([]File, error) {
+	files, err := walkCompileExtensionFiles(dir, "", excludeFiles, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// walkCompileExtensionFiles lists the files in dir, named as if dir was at
+// prefix. Directories in visiting are being walked already, so links back to
+// them are skipped.
+func walkCompileExtensionFiles(dir, prefix string, excludeFiles []string, visiting map[string]bool) ([]File, error) {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	if visiting[realDir] {
+		return nil, nil
+	}
+	visiting[realDir] = true
+	defer delete(visiting, realDir)
+
+	var files []File
+	err = filepath.Walk(realDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(realDir, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		relPath = filepath.ToSlash(filepath.Join(prefix, relPath))
+
+		symlink := info.Mode()&os.ModeSymlink != 0
+		if symlink {
+			if info, err = os.Stat(path); err != nil {
+				return fmt.Errorf("Error while reading symlink '%s': %v", path, err)
+			}
+		}
+
+		excluded, err := isExcluded(relPath, info.IsDir(), excludeFiles)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			if info.IsDir() && !symlink {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if symlink && info.IsDir() {
+			linked, err := walkCompileExtensionFiles(path, relPath, excludeFiles, visiting)
+			if err != nil {
+				return err
+			}
+			files = append(files, linked...)
+		} else if info.Mode().IsRegular() {
+			files = append(files, File{relPath, path})
+		}
+		return nil
+	})
+	return files, err
+}
+
+func isExcluded(relPath string, isDir bool, excludeFiles []string) (bool, error) {
+	if relPath == ".git" {
+		return true, nil
+	}
+
+	for _, pattern := range excludeFiles {
+		pattern = strings.TrimPrefix(pattern, "./")
+
+		if strings.HasSuffix(pattern, "/") {
+			if isDir && relPath == strings.TrimSuffix(pattern, "/") {
+				return true, nil
+			}
+			continue
+		}
+
+		for _, candidate := range []string{relPath, filepath.Base(relPath)} {
+			matched, err := filepath.Match(pattern, candidate)
+			if err != nil {
+				return false, fmt.Errorf("Invalid exclude_files pattern %s: %v", pattern, err)
+			}
+			if matched {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,217 @@
+package packager_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/packager"
+	yaml "gopkg.in/yaml.v2"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CompileExtensionPackage", func() {
+	var (
+		buildpackDir string
+		server       *httptest.Server
+		zipFile      string
+		stack        string
+		cached       bool
+		err          error
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/dependencies/php/php-7.1.2-linux-x64.tgz":
+				w.Write([]byte("php 7.1.2"))
+			case "/dependencies/php/php-7.2.0-linux-x64.tgz":
+				w.Write([]byte("php 7.2.0"))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		DeferCleanup(server.Close)
+
+		buildpackDir, err = os.MkdirTemp("", "compile_extension")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, buildpackDir)
+		Expect(libbuildpack.CopyDirectory("./fixtures/compile_extension", buildpackDir)).To(Succeed())
+
+		manifestYml, err := os.ReadFile(filepath.Join(buildpackDir, "manifest.yml"))
+		Expect(err).To(BeNil())
+		manifestYml = []byte(strings.ReplaceAll(string(manifestYml), "DEPENDENCY_HOST", server.URL))
+		Expect(os.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), manifestYml, 0644)).To(Succeed())
+
+		oldCacheDir := packager.CacheDir
+		packager.CacheDir, err = os.MkdirTemp("", "packager-cachedir")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, packager.CacheDir)
+		DeferCleanup(func() { packager.CacheDir = oldCacheDir })
+
+		stack = "cflinuxfs2"
+		cached = false
+	})
+
+	JustBeforeEach(func() {
+		zipFile, err = packager.CompileExtensionPackage(buildpackDir, "1.2.3", cached, stack)
+	})
+
+	translated := func(path string) string {
+		return "dependencies/" + strings.NewReplacer(":", "_", "/", "_").Replace(server.URL+path)
+	}
+
+	Context("uncached", func() {
+		It("names the zip after the language, stack and version", func() {
+			Expect(err).To(BeNil())
+			Expect(zipFile).To(Equal(filepath.Join(buildpackDir, "php_buildpack-cflinuxfs2-v1.2.3.zip")))
+		})
+
+		It("includes every file that is not excluded", func() {
+			Expect(err).To(BeNil())
+			Expect(ZipContents(zipFile, "bin/compile")).To(Equal("#!/bin/bash\necho compile\n"))
+			Expect(ZipContents(zipFile, "lib/thing.rb")).To(Equal("module Thing; end\n"))
+			Expect(ZipContents(zipFile, "compile-extensions/bin/translate_dependency_url")).To(ContainSubstring("translate"))
+			Expect(ZipContents(zipFile, "VERSION")).To(Equal("1.2.3"))
+		})
+
+		It("leaves out excluded directories and globs", func() {
+			Expect(err).To(BeNil())
+			_, err := ZipContents(zipFile, "cf_spec/spec_helper.rb")
+			Expect(err).To(HaveOccurred())
+			_, err = ZipContents(zipFile, "lib/debug.log")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("leaves out zips of earlier runs", func() {
+			Expect(err).To(BeNil())
+			zipFile, err = packager.CompileExtensionPackage(buildpackDir, "1.2.4", false, stack)
+			Expect(err).To(BeNil())
+			_, err := ZipContents(zipFile, "php_buildpack-cflinuxfs2-v1.2.3.zip")
+			Expect(err).To(HaveOccurred())
+		})
+
+		Context("the buildpack has symlinks", func() {
+			BeforeEach(func() {
+				Expect(os.Symlink("thing.rb", filepath.Join(buildpackDir, "lib", "linked.rb"))).To(Succeed())
+				Expect(os.Symlink("lib", filepath.Join(buildpackDir, "linked_lib"))).To(Succeed())
+				Expect(os.Symlink("..", filepath.Join(buildpackDir, "lib", "parent"))).To(Succeed())
+			})
+
+			It("includes the files they link to", func() {
+				Expect(err).To(BeNil())
+				Expect(ZipContents(zipFile, "lib/linked.rb")).To(Equal("module Thing; end\n"))
+				Expect(ZipContents(zipFile, "linked_lib/thing.rb")).To(Equal("module Thing; end\n"))
+				_, err := ZipContents(zipFile, "linked_lib/debug.log")
+				Expect(err).To(HaveOccurred())
+				_, err = ZipContents(zipFile, "lib/parent/bin/compile")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		It("does not include dependencies", func() {
+			Expect(err).To(BeNil())
+			_, err := ZipContents(zipFile, translated("/dependencies/php/php-7.1.2-linux-x64.tgz"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("restricts the manifest to the stack", func() {
+			Expect(err).To(BeNil())
+			manifestYml, err := ZipContents(zipFile, "manifest.yml")
+			Expect(err).To(BeNil())
+
+			var m packager.CompileExtensionManifest
+			Expect(yaml.Unmarshal([]byte(manifestYml), &m)).To(Succeed())
+			Expect(m.Stack).To(Equal("cflinuxfs2"))
+			Expect(m.Dependencies).To(HaveLen(1))
+			Expect(m.Dependencies[0].Version).To(Equal("7.1.2"))
+			Expect(m.Dependencies[0].CFStacks).To(BeEmpty())
+			Expect(m.Dependencies[0].Modules).To(Equal([]string{"bz2", "redis"}))
+			Expect(m.DeprecationDates[0].Match).To(Equal(`7\.1\.\d+`))
+		})
+	})
+
+	Context("cached", func() {
+		BeforeEach(func() { cached = true })
+
+		It("names the zip as cached", func() {
+			Expect(err).To(BeNil())
+			Expect(zipFile).To(Equal(filepath.Join(buildpackDir, "php_buildpack-cached-cflinuxfs2-v1.2.3.zip")))
+		})
+
+		It("includes dependencies for the stack under their translated names", func() {
+			Expect(err).To(BeNil())
+			Expect(ZipContents(zipFile, translated("/dependencies/php/php-7.1.2-linux-x64.tgz"))).To(Equal("php 7.1.2"))
+			_, err := ZipContents(zipFile, translated("/dependencies/php/php-7.2.0-linux-x64.tgz"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		Context("any stack", func() {
+			BeforeEach(func() { stack = "any" })
+
+			It("includes dependencies for every stack and keeps cf_stacks", func() {
+				Expect(err).To(BeNil())
+				Expect(zipFile).To(Equal(filepath.Join(buildpackDir, "php_buildpack-cached-v1.2.3.zip")))
+				Expect(ZipContents(zipFile, translated("/dependencies/php/php-7.1.2-linux-x64.tgz"))).To(Equal("php 7.1.2"))
+				Expect(ZipContents(zipFile, translated("/dependencies/php/php-7.2.0-linux-x64.tgz"))).To(Equal("php 7.2.0"))
+
+				manifestYml, err := ZipContents(zipFile, "manifest.yml")
+				Expect(err).To(BeNil())
+				Expect(manifestYml).To(ContainSubstring("cf_stacks"))
+				Expect(manifestYml).NotTo(ContainSubstring("stack: "))
+			})
+		})
+
+		Context("a cached dependency does not match its md5", func() {
+			BeforeEach(func() {
+				cacheFile := filepath.Join(packager.CacheDir, translated("/dependencies/php/php-7.1.2-linux-x64.tgz"))
+				Expect(os.MkdirAll(filepath.Dir(cacheFile), 0755)).To(Succeed())
+				Expect(os.WriteFile(cacheFile, []byte("truncated"), 0644)).To(Succeed())
+			})
+
+			It("downloads it again", func() {
+				Expect(err).To(BeNil())
+				Expect(ZipContents(zipFile, translated("/dependencies/php/php-7.1.2-linux-x64.tgz"))).To(Equal("php 7.1.2"))
+			})
+		})
+
+		Context("a dependency does not match its md5", func() {
+			BeforeEach(func() {
+				manifestYml, err := os.ReadFile(filepath.Join(buildpackDir, "manifest.yml"))
+				Expect(err).To(BeNil())
+				manifestYml = []byte(strings.ReplaceAll(string(manifestYml), "871ae40bd784f676acee9524421fa8c3", "00000000000000000000000000000000"))
+				Expect(os.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), manifestYml, 0644)).To(Succeed())
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("dependency md5 mismatch")))
+			})
+		})
+	})
+
+	Context("stack not found in any dependencies", func() {
+		BeforeEach(func() { stack = "nonexistent-stack" })
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError("Stack `nonexistent-stack` not found in manifest"))
+		})
+	})
+
+	Context("a deprecation date has an invalid match", func() {
+		BeforeEach(func() {
+			manifestYml, err := os.ReadFile(filepath.Join(buildpackDir, "manifest.yml"))
+			Expect(err).To(BeNil())
+			manifestYml = []byte(strings.ReplaceAll(string(manifestYml), `match: 7\.1\.\d+`, `match: 7\.1\.(`))
+			Expect(os.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), manifestYml, 0644)).To(Succeed())
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError(ContainSubstring("Invalid deprecation match")))
+		})
+	})
+})
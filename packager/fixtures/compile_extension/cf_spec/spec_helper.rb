@@ -0,0 +1 @@
+spec
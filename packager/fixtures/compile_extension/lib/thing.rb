@@ -0,0 +1 @@
+module Thing; end
@@ -16,7 +16,6 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
-	"strings"
 
 	"github.com/cloudfoundry/libbuildpack"
 	"gopkg.in/yaml.v2"
@@ -47,61 +46,6 @@ func readManifest(bpDir string) (Manifest, error) {
 var CacheDir = filepath.Join(os.Getenv("HOME"), ".buildpack-packager", "cache")
 var Stdout, Stderr io.Writer = os.Stdout, os.Stderr
 
-func CompileExtensionPackage(bpDir, version string, cached bool, stack string) (string, error) {
-	bpDir, err := filepath.Abs(bpDir)
-	if err != nil {
-		return "", fmt.Errorf("Failed to get the absolute path of %s: %v", bpDir, err)
-	}
-	dir, err := CopyDirectory(bpDir)
-	if err != nil {
-		return "", fmt.Errorf("Failed to copy %s: %v", bpDir, err)
-	}
-
-	err = os.WriteFile(filepath.Join(dir, "VERSION"), []byte(version), 0644)
-	if err != nil {
-		return "", fmt.Errorf("Failed to write VERSION file: %v", err)
-	}
-
-	isCached := "--uncached"
-	if cached {
-		isCached = "--cached"
-	}
-	stackArg := "--stack=" + stack
-	if stack == "any" {
-		stackArg = "--any-stack"
-	}
-	cmd := exec.Command("bundle", "exec", "buildpack-packager", isCached, stackArg)
-	cmd.Stdout = Stdout
-	cmd.Stderr = Stderr
-	cmd.Env = append(os.Environ(), "BUNDLE_GEMFILE=cf.Gemfile")
-	cmd.Dir = dir
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("Failed to run %s %s: %v", cmd.Path, strings.Join(cmd.Args, " "), err)
-	}
-
-	var manifest struct {
-		Language string `yaml:"language"`
-	}
-
-	if err := libbuildpack.NewYAML().Load(filepath.Join(bpDir, "manifest.yml"), &manifest); err != nil {
-		return "", fmt.Errorf("Failed to load manifest.yml: %v", err)
-	}
-
-	stackName := fmt.Sprintf("-%s", stack)
-	if stackName == "any" {
-		stackName = ""
-	}
-	zipFile := fmt.Sprintf("%s_buildpack%s-v%s.zip", manifest.Language, stackName, version)
-	if cached {
-		zipFile = fmt.Sprintf("%s_buildpack-cached%s-v%s.zip", manifest.Language, stackName, version)
-	}
-	if err := libbuildpack.CopyFile(filepath.Join(dir, zipFile), filepath.Join(bpDir, zipFile)); err != nil {
-		return "", fmt.Errorf("Failed to copy %s from %s to %s: %v", zipFile, dir, bpDir, err)
-	}
-
-	return filepath.Join(dir, zipFile), nil
-}
-
 func validateStack(stack, bpDir string) error {
 	manifest, err := readManifest(bpDir)
 	if err != nil {
@@ -3,59 +3,18 @@ package main
 import (
 	"{{LANGUAGE}}/finalize"
 	_ "{{LANGUAGE}}/hooks"
-	"os"
-	"time"
 
 	"github.com/cloudfoundry/libbuildpack"
 )
 
 func main() {
-	logger := libbuildpack.NewLogger(os.Stdout)
-
-	buildpackDir, err := libbuildpack.GetBuildpackDir()
-	if err != nil {
-		logger.Error("Unable to determine buildpack directory: %s", err)
-		os.Exit(9)
-	}
-
-	manifest, err := libbuildpack.NewManifest(buildpackDir, logger, time.Now())
-	if err != nil {
-		logger.Error("Unable to load buildpack manifest: %s", err)
-		os.Exit(10)
-	}
-
-	stager := libbuildpack.NewStager(os.Args[1:], logger, manifest)
-
-	if err = manifest.ApplyOverride(stager.DepsDir()); err != nil {
-		logger.Error("Unable to apply override.yml files: %s", err)
-		os.Exit(17)
-	}
-
-	if err := stager.SetStagingEnvironment(); err != nil {
-		logger.Error("Unable to setup environment variables: %s", err)
-		os.Exit(11)
-	}
-
-	f := finalize.Finalizer{
-		Manifest: manifest,
-		Stager:   stager,
-		Command:  &libbuildpack.Command{},
-		Log:      logger,
-	}
-
-	if err := f.Run(); err != nil {
-		os.Exit(12)
-	}
-
-	if err := libbuildpack.RunAfterCompile(stager); err != nil {
-		logger.Error("After Compile: %s", err)
-		os.Exit(13)
-	}
-
-	if err := stager.SetLaunchEnvironment(); err != nil {
-		logger.Error("Unable to setup launch environment: %s", err)
-		os.Exit(14)
-	}
-
-	stager.StagingComplete()
+	libbuildpack.RunFinalize(func(ctx *libbuildpack.FinalizeContext) error {
+		f := finalize.Finalizer{
+			Manifest: ctx.Manifest,
+			Stager:   ctx.Stager,
+			Command:  ctx.Command,
+			Log:      ctx.Log,
+		}
+		return f.Run()
+	})
 }
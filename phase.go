@@ -0,0 +1,279 @@
+package libbuildpack
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+// Exit codes of SupplyMain and FinalizeMain, named after the step that
+// failed. They are the codes the mains of the scaffold exit with, so
+// buildpacks keep them when they switch to RunSupply and RunFinalize; steps
+// the scaffold does not have use codes above 19.
+const (
+	ExitBuildpackDir     = 9
+	ExitManifest         = 10
+	ExitBuildpackInvalid = 11
+	ExitBeforeCompile    = 12
+	ExitDepDirs          = 13
+	ExitStagingEnv       = 14
+	ExitPhase            = 15
+	ExitConfigYml        = 16
+	ExitOverride         = 17
+	ExitAppCacheDir      = 18
+	ExitCleanupAppCache  = 19
+	ExitPanic            = 22
+	ExitBeforePhase      = 23
+	ExitAfterPhase       = 24
+)
+
+// Exit codes of FinalizeMain for the steps the finalize main of the scaffold
+// numbers differently from supply.
+const (
+	ExitFinalizeStagingEnv = 11
+	ExitFinalize           = 12
+	ExitAfterCompile       = 13
+	ExitLaunchEnv          = 14
+)
+
+type SupplyContext struct {
+	Manifest  *Manifest
+	Installer *Installer
+	Stager    *Stager
+	Command   *Command
+	Log       *Logger
+
+	// Config is written to <DepDir>/config.yml once the supply func returns.
+	Config interface{}
+}
+
+type FinalizeContext struct {
+	Manifest  *Manifest
+	Installer *Installer
+	Stager    *Stager
+	Command   *Command
+	Log       *Logger
+}
+
+// RunSupply is the main of bin/supply. It sets up the manifest, installer and
+// stager from the command line, runs supply between the BeforeSupply and
+// AfterSupply hooks, writes config.yml and exits with one of the Exit codes.
+// The OnFailure hooks run if any step fails. Setting ExecHooksEnv also runs
+// the executable hooks of the buildpack; see ExecHook.
+func RunSupply(supply func(*SupplyContext) error) {
+	os.Exit(SupplyMain(os.Args[1:], NewLogger(os.Stdout), supply))
+}
+
+// RunFinalize is the main of bin/finalize. It sets up the manifest, installer
+// and stager from the command line, runs finalize between the BeforeFinalize
+// and AfterFinalize hooks, runs the AfterCompile hooks, sets up the launch
+// environment and exits with one of the Exit codes. The OnFailure hooks run if
+// any step fails, and ExecHooksEnv turns on executable hooks like for
+// RunSupply.
+func RunFinalize(finalize func(*FinalizeContext) error) {
+	os.Exit(FinalizeMain(os.Args[1:], NewLogger(os.Stdout), finalize))
+}
+
+// SupplyMain runs the supply phase for RunSupply and returns its exit code.
+// The secrets in the env and in the credentials of bound services are
+// registered with the redactor of logger.
+func SupplyMain(args []string, logger *Logger, supply func(*SupplyContext) error) (code int) {
+	var stager *Stager
+	defer logger.Flush()
+	defer writePhasePlan(logger)
+	defer recoverPhase(logger, &stager, &code)
+	RegisterStagingSecrets(logger)
+
+	manifest, code := loadPhaseManifest(logger)
+	if code != 0 {
+		return code
+	}
+	installer := NewInstaller(manifest)
+
+	stager = NewStager(args, logger, manifest)
+	if err := addExecHook(stager); err != nil {
+		return failPhase(stager, ExitBeforePhase, "Unable to set up executable hooks: %s", err)
+	}
+	if err := stager.CheckBuildpackValid(); err != nil {
+		return failPhase(stager, ExitBuildpackInvalid, "", err)
+	}
+
+	if err := installer.SetAppCacheDir(stager.CacheDir()); err != nil {
+		return failPhase(stager, ExitAppCacheDir, "Unable to setup app cache dir: %s", err)
+	}
+	if err := manifest.ApplyOverride(stager.DepsDir()); err != nil {
+		return failPhase(stager, ExitOverride, "Unable to apply override.yml files: %s", err)
+	}
+
+	if err := RunBeforeCompile(stager); err != nil {
+		return failPhase(stager, ExitBeforeCompile, "Before Compile: %s", err)
+	}
+
+	for _, dir := range []string{"bin", "lib"} {
+		if Planning() {
+			break
+		}
+		if err := os.MkdirAll(filepath.Join(stager.DepDir(), dir), 0755); err != nil {
+			return failPhase(stager, ExitDepDirs, "Unable to create "+dir+" directory: %s", err)
+		}
+	}
+
+	if err := stager.SetStagingEnvironment(); err != nil {
+		return failPhase(stager, ExitStagingEnv, "Unable to setup environment variables: %s", err)
+	}
+
+	if err := RunBeforeSupply(stager); err != nil {
+		return failPhase(stager, ExitBeforePhase, "Before Supply: %s", err)
+	}
+
+	ctx := &SupplyContext{
+		Manifest:  manifest,
+		Installer: installer,
+		Stager:    stager,
+		Command:   &Command{},
+		Log:       logger,
+	}
+	if err := supply(ctx); err != nil {
+		return failPhase(stager, ExitPhase, "Error: %s", err)
+	}
+
+	if err := RunAfterSupply(stager); err != nil {
+		return failPhase(stager, ExitAfterPhase, "After Supply: %s", err)
+	}
+
+	if err := stager.WriteConfigYml(ctx.Config); err != nil {
+		return failPhase(stager, ExitConfigYml, "Error writing config.yml: %s", err)
+	}
+	if err := installer.CleanupAppCache(); err != nil {
+		return failPhase(stager, ExitCleanupAppCache, "Unable clean up app cache: %s", err)
+	}
+
+	stager.StagingComplete()
+
+	if err := stager.WriteStagingReport(); err != nil {
+		logger.Warning("Unable to write staging report: %s", err)
+	}
+
+	return 0
+}
+
+// FinalizeMain runs the finalize phase for RunFinalize and returns its exit
+// code. It registers secrets like SupplyMain.
+func FinalizeMain(args []string, logger *Logger, finalize func(*FinalizeContext) error) (code int) {
+	var stager *Stager
+	defer logger.Flush()
+	defer writePhasePlan(logger)
+	defer recoverPhase(logger, &stager, &code)
+	RegisterStagingSecrets(logger)
+
+	manifest, code := loadPhaseManifest(logger)
+	if code != 0 {
+		return code
+	}
+
+	stager = NewStager(args, logger, manifest)
+	if err := addExecHook(stager); err != nil {
+		return failPhase(stager, ExitBeforePhase, "Unable to set up executable hooks: %s", err)
+	}
+
+	if err := manifest.ApplyOverride(stager.DepsDir()); err != nil {
+		return failPhase(stager, ExitOverride, "Unable to apply override.yml files: %s", err)
+	}
+
+	if err := stager.SetStagingEnvironment(); err != nil {
+		return failPhase(stager, ExitFinalizeStagingEnv, "Unable to setup environment variables: %s", err)
+	}
+
+	if err := RunBeforeFinalize(stager); err != nil {
+		return failPhase(stager, ExitBeforePhase, "Before Finalize: %s", err)
+	}
+
+	ctx := &FinalizeContext{
+		Manifest:  manifest,
+		Installer: NewInstaller(manifest),
+		Stager:    stager,
+		Command:   &Command{},
+		Log:       logger,
+	}
+	if err := finalize(ctx); err != nil {
+		return failPhase(stager, ExitFinalize, "Error: %s", err)
+	}
+
+	if err := RunAfterFinalize(stager); err != nil {
+		return failPhase(stager, ExitAfterPhase, "After Finalize: %s", err)
+	}
+
+	if err := RunAfterCompile(stager); err != nil {
+		return failPhase(stager, ExitAfterCompile, "After Compile: %s", err)
+	}
+
+	if err := stager.SetLaunchEnvironment(); err != nil {
+		return failPhase(stager, ExitLaunchEnv, "Unable to setup launch environment: %s", err)
+	}
+
+	stager.StagingComplete()
+
+	if err := stager.WriteStagingReport(); err != nil {
+		logger.Warning("Unable to write staging report: %s", err)
+	}
+
+	return 0
+}
+
+// failPhase logs err with format, unless format is empty because err was
+// logged already, runs the OnFailure hooks and returns code.
+func failPhase(stager *Stager, code int, format string, err error) int {
+	if format != "" {
+		stager.log.Error(format, err)
+	}
+	if hookErr := RunOnFailure(stager, err); hookErr != nil {
+		stager.log.Warning("On Failure: %s", hookErr)
+	}
+	return code
+}
+
+// addExecHook adds an ExecHook for the buildpack to stager if ExecHooksEnv
+// turns them on.
+func addExecHook(stager *Stager) error {
+	if !ExecHooksEnabled() {
+		return nil
+	}
+
+	hook, err := NewExecHook(stager.manifest.RootDir())
+	if err != nil {
+		return err
+	}
+	stager.Hooks().Add(hook)
+	return nil
+}
+
+func loadPhaseManifest(logger *Logger) (*Manifest, int) {
+	buildpackDir, err := GetBuildpackDir()
+	if err != nil {
+		logger.Error("Unable to determine buildpack directory: %s", err)
+		return nil, ExitBuildpackDir
+	}
+
+	manifest, err := NewManifest(buildpackDir, logger, time.Now())
+	if err != nil {
+		logger.Error("Unable to load buildpack manifest: %s", err)
+		return nil, ExitManifest
+	}
+
+	return manifest, 0
+}
+
+// recoverPhase turns a panic of the phase into ExitPanic, and runs the
+// OnFailure hooks with it once the stager is set up.
+func recoverPhase(logger *Logger, stager **Stager, code *int) {
+	if r := recover(); r != nil {
+		logger.Error("%s", fmt.Sprint(r))
+		logger.Debug("%s", debug.Stack())
+		*code = ExitPanic
+		if *stager != nil {
+			failPhase(*stager, ExitPanic, "", fmt.Errorf("panic: %v", r))
+		}
+	}
+}
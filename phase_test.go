@@ -0,0 +1,361 @@
+package libbuildpack_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Phase runner", func() {
+	var (
+		buildDir string
+		cacheDir string
+		depsDir  string
+		args     []string
+		buffer   *bytes.Buffer
+		logger   *libbuildpack.Logger
+		err      error
+	)
+
+	BeforeEach(func() {
+		buildDir, err = os.MkdirTemp("", "build")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, buildDir)
+
+		cacheDir, err = os.MkdirTemp("", "cache")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, cacheDir)
+
+		depsDir, err = os.MkdirTemp("", "deps")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, depsDir)
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+		args = []string{buildDir, cacheDir, depsDir, "0"}
+		buffer = new(bytes.Buffer)
+		logger = libbuildpack.NewLogger(buffer)
+
+		DeferCleanup(os.Setenv, "BUILDPACK_DIR", os.Getenv("BUILDPACK_DIR"))
+		os.Setenv("BUILDPACK_DIR", filepath.Join("fixtures", "manifest", "standard"))
+		DeferCleanup(os.Setenv, "CF_STACK", os.Getenv("CF_STACK"))
+		os.Setenv("CF_STACK", "cflinuxfs2")
+
+		libbuildpack.ClearHooks()
+		DeferCleanup(libbuildpack.ClearHooks)
+	})
+
+	Describe("SupplyMain", func() {
+		It("runs supply and writes config.yml", func() {
+			code := libbuildpack.SupplyMain(args, logger, func(ctx *libbuildpack.SupplyContext) error {
+				Expect(ctx.Stager.DepDir()).To(Equal(filepath.Join(depsDir, "0")))
+				Expect(filepath.Join(ctx.Stager.DepDir(), "bin")).To(BeADirectory())
+				ctx.Config = map[string]string{"key": "value"}
+				return nil
+			})
+			Expect(code).To(Equal(0))
+
+			var config map[string]interface{}
+			Expect(libbuildpack.NewYAML().Load(filepath.Join(depsDir, "0", "config.yml"), &config)).To(Succeed())
+			Expect(config["name"]).To(Equal("dotnet-core"))
+			Expect(config["config"]).To(Equal(map[interface{}]interface{}{"key": "value"}))
+		})
+
+		It("records the dependencies it installed in BUILDPACK_METADATA", func() {
+			code := libbuildpack.SupplyMain(args, logger, func(ctx *libbuildpack.SupplyContext) error {
+				return ctx.Manifest.RecordDependency(libbuildpack.Dependency{Name: "libunwind", Version: "1.2"})
+			})
+			Expect(code).To(Equal(0))
+
+			var md libbuildpack.BuildpackMetadata
+			Expect(libbuildpack.NewYAML().Load(filepath.Join(cacheDir, "BUILDPACK_METADATA"), &md)).To(Succeed())
+			Expect(md.Dependencies).To(Equal([]libbuildpack.DependencyFingerprint{{Name: "libunwind", Version: "1.2"}}))
+		})
+
+		It("masks the secret credentials of bound services", func() {
+			DeferCleanup(os.Setenv, "VCAP_SERVICES", os.Getenv("VCAP_SERVICES"))
+			vcapServices, err := libbuildpack.NewVCAPServices(libbuildpack.Service{
+				Name:        "monitoring",
+				Label:       "newrelic",
+				Credentials: map[string]interface{}{"licenseKey": "0123456789abcdef"},
+			})
+			Expect(err).To(BeNil())
+			os.Setenv("VCAP_SERVICES", vcapServices)
+			logger.SetRedactor(libbuildpack.NewRedactor())
+
+			code := libbuildpack.SupplyMain(args, logger, func(ctx *libbuildpack.SupplyContext) error {
+				ctx.Log.Info("license 0123456789abcdef")
+				return nil
+			})
+			Expect(code).To(Equal(0))
+			Expect(buffer.String()).To(ContainSubstring("license -redacted-"))
+		})
+
+		It("returns ExitPhase when supply fails", func() {
+			code := libbuildpack.SupplyMain(args, logger, func(*libbuildpack.SupplyContext) error {
+				return errors.New("no node for you")
+			})
+			Expect(code).To(Equal(libbuildpack.ExitPhase))
+			Expect(buffer.String()).To(ContainSubstring("no node for you"))
+			Expect(filepath.Join(depsDir, "0", "config.yml")).NotTo(BeAnExistingFile())
+		})
+
+		It("returns ExitBuildpackInvalid when the stack is not supported", func() {
+			os.Setenv("CF_STACK", "unknown-stack")
+
+			code := libbuildpack.SupplyMain(args, logger, func(*libbuildpack.SupplyContext) error {
+				Fail("supply should not run")
+				return nil
+			})
+			Expect(code).To(Equal(libbuildpack.ExitBuildpackInvalid))
+		})
+
+		It("returns ExitManifest when the manifest cannot be loaded", func() {
+			os.Setenv("BUILDPACK_DIR", buildDir)
+
+			Expect(libbuildpack.SupplyMain(args, logger, func(*libbuildpack.SupplyContext) error { return nil })).To(Equal(libbuildpack.ExitManifest))
+		})
+
+		It("returns ExitBeforeCompile when a hook fails", func() {
+			libbuildpack.AddHook(failingHook{before: errors.New("hook failed")})
+
+			Expect(libbuildpack.SupplyMain(args, logger, func(*libbuildpack.SupplyContext) error { return nil })).To(Equal(libbuildpack.ExitBeforeCompile))
+			Expect(buffer.String()).To(ContainSubstring("Before Compile: hook failed"))
+		})
+
+		It("runs the supply hooks around supply", func() {
+			var events []string
+			libbuildpack.AddHook(&recordingHook{name: "rec", events: &events})
+
+			code := libbuildpack.SupplyMain(args, logger, func(*libbuildpack.SupplyContext) error {
+				events = append(events, "supply")
+				return nil
+			})
+			Expect(code).To(Equal(0))
+			Expect(events).To(Equal([]string{"rec BeforeCompile", "rec BeforeSupply", "supply", "rec AfterSupply"}))
+		})
+
+		It("runs the failure hooks when supply fails", func() {
+			var events []string
+			libbuildpack.AddHook(&recordingHook{name: "rec", events: &events})
+
+			code := libbuildpack.SupplyMain(args, logger, func(*libbuildpack.SupplyContext) error {
+				return errors.New("no node for you")
+			})
+			Expect(code).To(Equal(libbuildpack.ExitPhase))
+			Expect(events).To(ContainElement("rec OnFailure no node for you"))
+			Expect(events).NotTo(ContainElement("rec AfterSupply"))
+		})
+
+		It("runs executable hooks when BP_EXEC_HOOKS is set", func() {
+			if runtime.GOOS == "windows" {
+				Skip("hook scripts are shell scripts")
+			}
+			buildpackDir, err := os.MkdirTemp("", "buildpack")
+			Expect(err).To(BeNil())
+			DeferCleanup(os.RemoveAll, buildpackDir)
+			Expect(libbuildpack.CopyDirectory(filepath.Join("fixtures", "manifest", "standard"), buildpackDir)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(buildpackDir, "hooks", "after-supply.d"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildpackDir, "hooks", "after-supply.d", "scan"), []byte("#!/bin/sh\necho scanned\n"), 0755)).To(Succeed())
+			os.Setenv("BUILDPACK_DIR", buildpackDir)
+			DeferCleanup(os.Unsetenv, libbuildpack.ExecHooksEnv)
+
+			Expect(libbuildpack.SupplyMain(args, logger, func(*libbuildpack.SupplyContext) error { return nil })).To(Equal(0))
+			Expect(buffer.String()).NotTo(ContainSubstring("scanned"))
+
+			os.Setenv(libbuildpack.ExecHooksEnv, "true")
+			Expect(libbuildpack.SupplyMain(args, logger, func(*libbuildpack.SupplyContext) error { return nil })).To(Equal(0))
+			Expect(buffer.String()).To(ContainSubstring("-----> Running hook scan\n       scanned\n"))
+		})
+
+		It("returns ExitAfterPhase when an after supply hook fails", func() {
+			libbuildpack.AddHook(afterSupplyFailure{})
+
+			Expect(libbuildpack.SupplyMain(args, logger, func(*libbuildpack.SupplyContext) error { return nil })).To(Equal(libbuildpack.ExitAfterPhase))
+			Expect(buffer.String()).To(ContainSubstring("After Supply: after failed"))
+		})
+
+		It("recovers from panics", func() {
+			code := libbuildpack.SupplyMain(args, logger, func(*libbuildpack.SupplyContext) error {
+				panic("something went wrong")
+			})
+			Expect(code).To(Equal(libbuildpack.ExitPanic))
+			Expect(buffer.String()).To(ContainSubstring("something went wrong"))
+		})
+
+		It("runs the failure hooks when supply panics", func() {
+			var events []string
+			libbuildpack.AddHook(&recordingHook{name: "rec", events: &events})
+
+			code := libbuildpack.SupplyMain(args, logger, func(*libbuildpack.SupplyContext) error {
+				panic("something went wrong")
+			})
+			Expect(code).To(Equal(libbuildpack.ExitPanic))
+			Expect(events).To(Equal([]string{"rec BeforeCompile", "rec BeforeSupply", "rec OnFailure panic: something went wrong"}))
+		})
+	})
+
+	Describe("FinalizeMain", func() {
+		It("runs finalize and sets up the launch environment", func() {
+			code := libbuildpack.FinalizeMain(args, logger, func(ctx *libbuildpack.FinalizeContext) error {
+				return ctx.Stager.WriteProfileD("finalized.sh", "export FINALIZED=true")
+			})
+			Expect(code).To(Equal(0))
+
+			Expect(filepath.Join(depsDir, "0", "profile.d", "finalized.sh")).To(BeAnExistingFile())
+			Expect(filepath.Join(buildDir, ".profile.d", "000_multi-supply.sh")).To(BeAnExistingFile())
+			Expect(filepath.Join(cacheDir, "BUILDPACK_METADATA")).To(BeAnExistingFile())
+		})
+
+		It("stores the dependencies installed during supply in BUILDPACK_METADATA", func() {
+			Expect(libbuildpack.SupplyMain(args, logger, func(ctx *libbuildpack.SupplyContext) error {
+				return ctx.Manifest.RecordDependency(libbuildpack.Dependency{Name: "libunwind", Version: "1.2"})
+			})).To(Equal(0))
+
+			manifest, err := libbuildpack.NewManifest(filepath.Join("fixtures", "manifest", "standard"), logger, time.Now())
+			Expect(err).To(BeNil())
+			libunwind := libbuildpack.Dependency{Name: "libunwind", Version: "1.2"}
+			Expect(manifest.ChangedDependencies(cacheDir, libunwind)).To(BeEmpty())
+
+			code := libbuildpack.FinalizeMain(args, logger, func(ctx *libbuildpack.FinalizeContext) error {
+				return ctx.Manifest.RecordDependency(libbuildpack.Dependency{Name: "ruby", Version: "2.3.3"})
+			})
+			Expect(code).To(Equal(0))
+
+			var md libbuildpack.BuildpackMetadata
+			Expect(libbuildpack.NewYAML().Load(filepath.Join(cacheDir, "BUILDPACK_METADATA"), &md)).To(Succeed())
+			Expect(md.Dependencies).To(Equal([]libbuildpack.DependencyFingerprint{
+				{Name: "libunwind", Version: "1.2"},
+				{Name: "ruby", Version: "2.3.3"},
+			}))
+
+			var report libbuildpack.StagingReport
+			Expect(libbuildpack.NewJSON().Load(filepath.Join(depsDir, "0", "staging-report.json"), &report)).To(Succeed())
+			Expect(report.Dependencies).To(HaveLen(2))
+		})
+
+		It("returns ExitFinalize when finalize fails", func() {
+			code := libbuildpack.FinalizeMain(args, logger, func(*libbuildpack.FinalizeContext) error {
+				return errors.New("cannot finalize")
+			})
+			Expect(code).To(Equal(libbuildpack.ExitFinalize))
+			Expect(filepath.Join(cacheDir, "BUILDPACK_METADATA")).NotTo(BeAnExistingFile())
+		})
+
+		It("runs the finalize hooks around finalize, before the after compile hooks", func() {
+			var events []string
+			libbuildpack.AddHook(&recordingHook{name: "rec", events: &events})
+
+			code := libbuildpack.FinalizeMain(args, logger, func(*libbuildpack.FinalizeContext) error {
+				events = append(events, "finalize")
+				return nil
+			})
+			Expect(code).To(Equal(0))
+			Expect(events).To(Equal([]string{"rec BeforeFinalize", "finalize", "rec AfterFinalize", "rec AfterCompile"}))
+		})
+
+		It("returns ExitAfterCompile when a hook fails", func() {
+			libbuildpack.AddHook(failingHook{after: errors.New("hook failed")})
+
+			Expect(libbuildpack.FinalizeMain(args, logger, func(*libbuildpack.FinalizeContext) error { return nil })).To(Equal(libbuildpack.ExitAfterCompile))
+			Expect(buffer.String()).To(ContainSubstring("After Compile: hook failed"))
+		})
+
+		It("recovers from panics", func() {
+			code := libbuildpack.FinalizeMain(args, logger, func(*libbuildpack.FinalizeContext) error {
+				var m map[string]string
+				m["boom"] = "x"
+				return nil
+			})
+			Expect(code).To(Equal(libbuildpack.ExitPanic))
+			Expect(buffer.String()).To(ContainSubstring("assignment to entry in nil map"))
+		})
+	})
+
+	Describe("plan mode", func() {
+		snapshot := func(dirs ...string) map[string]string {
+			files := map[string]string{}
+			for _, dir := range dirs {
+				Expect(filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+					if err != nil {
+						return err
+					}
+					contents := info.Mode().String()
+					if info.Mode().IsRegular() {
+						data, err := os.ReadFile(path)
+						if err != nil {
+							return err
+						}
+						contents += " " + string(data)
+					}
+					files[path] = contents
+					return nil
+				})).To(Succeed())
+			}
+			return files
+		}
+
+		BeforeEach(func() {
+			buildpackDir, err := os.MkdirTemp("", "buildpack")
+			Expect(err).To(BeNil())
+			DeferCleanup(os.RemoveAll, buildpackDir)
+			Expect(libbuildpack.CopyDirectory(filepath.Join("fixtures", "manifest", "standard"), buildpackDir)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(buildpackDir, "hooks", "before-supply.d"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildpackDir, "hooks", "before-supply.d", "touch"), []byte("#!/bin/sh\ntouch \"$BUILD_DIR/touched\"\n"), 0755)).To(Succeed())
+			os.Setenv("BUILDPACK_DIR", buildpackDir)
+
+			DeferCleanup(os.Setenv, libbuildpack.ExecHooksEnv, os.Getenv(libbuildpack.ExecHooksEnv))
+			os.Setenv(libbuildpack.ExecHooksEnv, "true")
+			DeferCleanup(os.Setenv, libbuildpack.PlanEnv, os.Getenv(libbuildpack.PlanEnv))
+			os.Setenv(libbuildpack.PlanEnv, filepath.Join(buildpackDir, "plan.json"))
+
+			Expect(os.MkdirAll(filepath.Join(cacheDir, "dependencies", "abc"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(cacheDir, "dependencies", "abc", "node.tgz"), []byte("cached"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(cacheDir, "BUILDPACK_METADATA"), []byte("earlier"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "app.js"), []byte("app"), 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0", "profile.d"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, "0", "profile.d", "supplied.sh"), []byte("export SUPPLIED=1"), 0644)).To(Succeed())
+		})
+
+		It("leaves the build, cache and deps dirs unchanged", func() {
+			if runtime.GOOS == "windows" {
+				Skip("the hook is a shell script")
+			}
+			before := snapshot(buildDir, cacheDir, depsDir)
+
+			Expect(libbuildpack.SupplyMain(args, logger, func(*libbuildpack.SupplyContext) error { return nil })).To(Equal(0))
+			Expect(libbuildpack.FinalizeMain(args, logger, func(*libbuildpack.FinalizeContext) error { return nil })).To(Equal(0))
+
+			Expect(snapshot(buildDir, cacheDir, depsDir)).To(Equal(before))
+
+			plan, err := libbuildpack.LoadPlan(os.Getenv(libbuildpack.PlanEnv))
+			Expect(err).To(BeNil())
+			var actions []string
+			for _, action := range plan.Actions {
+				actions = append(actions, action.Action)
+			}
+			Expect(actions).To(ContainElements(libbuildpack.PlanRunHook, libbuildpack.PlanDelete, libbuildpack.PlanWriteProfileD, libbuildpack.PlanWriteFile))
+		})
+	})
+})
+
+type failingHook struct {
+	before error
+	after  error
+}
+
+func (h failingHook) BeforeCompile(*libbuildpack.Stager) error { return h.before }
+func (h failingHook) AfterCompile(*libbuildpack.Stager) error  { return h.after }
+
+type afterSupplyFailure struct {
+	libbuildpack.DefaultHook
+}
+
+func (afterSupplyFailure) AfterSupply(*libbuildpack.Stager) error { return errors.New("after failed") }
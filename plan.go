@@ -0,0 +1,150 @@
+package libbuildpack
+
+import (
+	"os"
+	"strconv"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// PlanEnv names the env var that turns on plan mode. When it is set to a file
+// path, the Installer, Stager and phase runners record the actions they would
+// take in that file as JSON instead of touching the filesystem or network. Actions of
+// every buildpack and phase are appended to the same plan. They are kept in
+// memory until WritePlan is called, which SupplyMain and FinalizeMain do when
+// the phase ends.
+const PlanEnv = "BP_PLAN"
+
+const (
+	PlanInstallDependency = "install_dependency"
+	PlanWriteEnvFile      = "write_env_file"
+	PlanWriteProfileD     = "write_profile_d"
+	PlanLinkDirectory     = "link_directory"
+	PlanSymlink           = "symlink"
+	PlanLink              = "link"
+	PlanWriteFile         = "write_file"
+	PlanRestoreCache      = "restore_cache"
+	PlanStoreCache        = "store_cache"
+	PlanDelete            = "delete"
+	PlanRunHook           = "run_hook"
+)
+
+type PlanAction struct {
+	Buildpack string            `json:"buildpack"`
+	Action    string            `json:"action"`
+	Target    string            `json:"target"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+type Plan struct {
+	Actions []PlanAction `json:"actions"`
+}
+
+// Planning reports whether plan mode is on.
+func Planning() bool {
+	return os.Getenv(PlanEnv) != ""
+}
+
+// LoadPlan reads the plan recorded in file.
+func LoadPlan(file string) (Plan, error) {
+	var plan Plan
+	if err := NewJSON().Load(file, &plan); err != nil {
+		if os.IsNotExist(err) {
+			return Plan{}, nil
+		}
+		return Plan{}, err
+	}
+	return plan, nil
+}
+
+var pendingPlan struct {
+	sync.Mutex
+	actions []PlanAction
+}
+
+// WritePlan appends the actions recorded since the last call to the plan
+// file. It does nothing unless plan mode is on.
+func WritePlan() error {
+	pendingPlan.Lock()
+	defer pendingPlan.Unlock()
+
+	actions := pendingPlan.actions
+	pendingPlan.actions = nil
+	if !Planning() || len(actions) == 0 {
+		return nil
+	}
+
+	file := os.Getenv(PlanEnv)
+	plan, err := LoadPlan(file)
+	if err != nil {
+		return err
+	}
+	plan.Actions = append(plan.Actions, actions...)
+	return NewJSON().Write(file, plan)
+}
+
+// recordPlanAction adds an action to the plan, with secrets in its target and
+// details masked.
+func (m *Manifest) recordPlanAction(action, target string, details map[string]string) error {
+	redactor := globalRedactor
+	if m.log != nil {
+		redactor = m.log.Redactor()
+	}
+	redacted := make(map[string]string, len(details))
+	for name, value := range details {
+		redacted[name] = redactor.Redact(value)
+	}
+
+	pendingPlan.Lock()
+	defer pendingPlan.Unlock()
+	pendingPlan.actions = append(pendingPlan.actions, PlanAction{
+		Buildpack: m.Language(),
+		Action:    action,
+		Target:    redactor.Redact(target),
+		Details:   redacted,
+	})
+	return nil
+}
+
+// planWriteYAML records writing obj to dest as YAML.
+func (m *Manifest) planWriteYAML(dest string, obj interface{}) error {
+	data, err := yaml.Marshal(&obj)
+	if err != nil {
+		return err
+	}
+	return m.recordPlanAction(PlanWriteFile, dest, map[string]string{"contents": string(data)})
+}
+
+// writePhasePlan writes the plan at the end of a phase.
+func writePhasePlan(logger *Logger) {
+	if err := WritePlan(); err != nil {
+		logger.Warning("Unable to write plan: %s", err)
+	}
+}
+
+func (i *Installer) planDependency(entry *ManifestEntry, outputDir string, stripComponents int) error {
+	source := "download"
+	if entry.File != "" {
+		source = "buildpack"
+	} else if i.appCacheDir != "" {
+		if exists, err := FileExists(i.appCacheFile(entry)); err != nil {
+			return err
+		} else if exists {
+			source = "app cache"
+		}
+	}
+
+	details := map[string]string{
+		"name":    entry.Dependency.Name,
+		"version": entry.Dependency.Version,
+		"uri":     entry.URI,
+		"sha256":  entry.SHA256,
+		"source":  source,
+	}
+	if stripComponents != 0 {
+		details["strip_components"] = strconv.Itoa(stripComponents)
+	}
+
+	return i.manifest.recordPlanAction(PlanInstallDependency, outputDir, details)
+}
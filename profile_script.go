@@ -0,0 +1,212 @@
+package libbuildpack
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ProfileScript builds a profile.d script from escaped operations instead of
+// raw shell. Values are used verbatim, except that ${NAME} references the env
+// var NAME at launch. Scripts render as POSIX shell, or as .bat on Windows.
+//
+// Scripts are sourced in weight order across all buildpacks, after the
+// multi-supply script; see WriteProfileScript.
+type ProfileScript struct {
+	name   string
+	weight int
+	ops    []profileOp
+	err    error
+}
+
+type profileOpKind int
+
+const (
+	profileExport profileOpKind = iota
+	profileSetDefault
+	profilePrependPath
+	profileAppendPath
+	profileSource
+	profileRaw
+	profileCredential
+)
+
+type profileOp struct {
+	kind  profileOpKind
+	name  string
+	value []profileValuePart
+	raw   string
+
+	// service and paths locate the credential of a profileCredential.
+	service string
+	paths   []string
+}
+
+type profileValuePart struct {
+	text string
+	ref  bool
+}
+
+var profileRefRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+var profileScriptNameRe = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+
+func NewProfileScript(name string) *ProfileScript {
+	p := &ProfileScript{name: strings.TrimSuffix(name, profileScriptExt)}
+	if !profileScriptNameRe.MatchString(p.name) {
+		p.err = fmt.Errorf("invalid profile script name %q", name)
+	}
+	return p
+}
+
+// DefaultProfileWeight is the weight of scripts without one, including those
+// written with WriteProfileD.
+const DefaultProfileWeight = 500
+
+// Weight orders the script across buildpacks; lower weights are sourced
+// first, and scripts of the same weight in buildpack order. A weight of 0
+// means DefaultProfileWeight.
+func (p *ProfileScript) Weight(weight int) *ProfileScript {
+	if weight < 0 || weight > 999 {
+		p.setErr(fmt.Errorf("profile script weight %d is not between 0 and 999", weight))
+	}
+	p.weight = weight
+	return p
+}
+
+func (p *ProfileScript) Export(name, value string) *ProfileScript {
+	return p.add(profileExport, name, value)
+}
+
+// SetDefault exports name only if it is unset or empty at launch.
+func (p *ProfileScript) SetDefault(name, value string) *ProfileScript {
+	return p.add(profileSetDefault, name, value)
+}
+
+func (p *ProfileScript) PrependPath(name, path string) *ProfileScript {
+	return p.add(profilePrependPath, name, path)
+}
+
+func (p *ProfileScript) AppendPath(name, path string) *ProfileScript {
+	return p.add(profileAppendPath, name, path)
+}
+
+// Source runs the script at path in the launch shell.
+func (p *ProfileScript) Source(path string) *ProfileScript {
+	p.ops = append(p.ops, profileOp{kind: profileSource, value: parseProfileValue(path)})
+	return p
+}
+
+// ExportCredential exports name at launch, set from the first of paths that
+// the credentials of the service named service have, like
+// Service.CredentialString. The credential is read from the binding of the
+// service under SERVICE_BINDING_ROOT, or else from VCAP_SERVICES with jq, or
+// PowerShell on Windows, so that secrets are not stored in the droplet. Only
+// top level credentials are read from bindings. name is left as it is if
+// none of paths is found.
+func (p *ProfileScript) ExportCredential(name, service string, paths ...string) *ProfileScript {
+	if !envVarNameRe.MatchString(name) {
+		p.setErr(fmt.Errorf("invalid environment variable name %s", name))
+	}
+	if len(paths) == 0 {
+		p.setErr(fmt.Errorf("no credential paths for %s", name))
+	}
+	for _, value := range append([]string{service}, paths...) {
+		if value == "" || strings.ContainsAny(value, "\"\r\n") {
+			p.setErr(fmt.Errorf("invalid service or credential name %q for %s", value, name))
+		}
+	}
+	p.ops = append(p.ops, profileOp{kind: profileCredential, name: name, service: service, paths: paths})
+	return p
+}
+
+// Raw adds a line verbatim. Lines that interpolate unquoted expansions or
+// command substitutions are refused by LintProfileScript.
+func (p *ProfileScript) Raw(line string) *ProfileScript {
+	p.ops = append(p.ops, profileOp{kind: profileRaw, raw: line})
+	return p
+}
+
+// FileName is the name of the script in <DepDir>/profile.d.
+func (p *ProfileScript) FileName() string {
+	if p.weight == 0 {
+		return p.name + profileScriptExt
+	}
+	return fmt.Sprintf("%03d_%s%s", p.weight, p.name, profileScriptExt)
+}
+
+// Contents renders the script and lints its raw lines; the other operations
+// escape what they interpolate.
+func (p *ProfileScript) Contents() (string, error) {
+	if p.err != nil {
+		return "", p.err
+	}
+
+	var lines []string
+	for _, op := range p.ops {
+		rendered := renderProfileOp(op)
+		if op.kind == profileRaw {
+			if err := lintProfileLine(rendered); err != nil {
+				return "", fmt.Errorf("profile script %s: line %d: %v: %s", p.name, len(lines)+1, err, rendered)
+			}
+		}
+		lines = append(lines, strings.Split(rendered, "\n")...)
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// WriteProfileScript writes script to <DepDir>/profile.d. Weighted scripts
+// go to <DepDir>/profile.d/weighted. SetLaunchEnvironment copies both as
+// <weight>_<idx>_<name>, so they sort by weight across buildpacks.
+func (s *Stager) WriteProfileScript(script *ProfileScript) error {
+	contents, err := script.Contents()
+	if err != nil {
+		return err
+	}
+
+	if script.weight == 0 {
+		return s.WriteProfileD(script.FileName(), contents)
+	}
+
+	if Planning() {
+		return s.manifest.recordPlanAction(PlanWriteProfileD, filepath.Join(s.DepDir(), "profile.d", "weighted", script.FileName()), map[string]string{"contents": contents})
+	}
+
+	weightedDir := filepath.Join(s.DepDir(), "profile.d", "weighted")
+	if err := os.MkdirAll(weightedDir, 0755); err != nil {
+		return err
+	}
+	return writeToFile(strings.NewReader(contents), filepath.Join(weightedDir, script.FileName()), 0755)
+}
+
+func (p *ProfileScript) add(kind profileOpKind, name, value string) *ProfileScript {
+	if !envVarNameRe.MatchString(name) {
+		p.setErr(fmt.Errorf("invalid environment variable name %s", name))
+	}
+	p.ops = append(p.ops, profileOp{kind: kind, name: name, value: parseProfileValue(value)})
+	return p
+}
+
+func (p *ProfileScript) setErr(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// parseProfileValue splits value into literal text and ${NAME} references.
+func parseProfileValue(value string) []profileValuePart {
+	var parts []profileValuePart
+	last := 0
+	for _, match := range profileRefRe.FindAllStringSubmatchIndex(value, -1) {
+		if match[0] > last {
+			parts = append(parts, profileValuePart{text: value[last:match[0]]})
+		}
+		parts = append(parts, profileValuePart{text: value[match[2]:match[3]], ref: true})
+		last = match[1]
+	}
+	if last < len(value) || len(parts) == 0 {
+		parts = append(parts, profileValuePart{text: value[last:]})
+	}
+	return parts
+}
@@ -0,0 +1,193 @@
+package libbuildpack_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ProfileScript", func() {
+	var (
+		depsDir    string
+		profileDir string
+		logger     *libbuildpack.Logger
+		manifest   *libbuildpack.Manifest
+	)
+
+	BeforeEach(func() {
+		if runtime.GOOS == "windows" {
+			Skip("profile.d scripts are sourced by sh on Linux only")
+		}
+
+		tmpDir, err := os.MkdirTemp("", "profile-script")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, tmpDir)
+		depsDir = filepath.Join(tmpDir, "deps")
+		profileDir = filepath.Join(tmpDir, "profile.d")
+
+		logger = libbuildpack.NewLogger(new(bytes.Buffer))
+		manifest, err = libbuildpack.NewManifest(filepath.Join("fixtures", "manifest", "standard"), logger, time.Now())
+		Expect(err).To(BeNil())
+	})
+
+	stagerFor := func(idx string) *libbuildpack.Stager {
+		Expect(os.MkdirAll(filepath.Join(depsDir, idx), 0755)).To(Succeed())
+		return libbuildpack.NewStager([]string{"", "", depsDir, idx, profileDir}, logger, manifest)
+	}
+
+	source := func(env []string, script string) string {
+		cmd := exec.Command("sh", "-c", `. "$1" && printf '%s|%s|%s|%s' "$GREETING" "$APP_PATH" "$LD_LIBRARY_PATH" "$MODE"`, "--", script)
+		cmd.Env = env
+		output, err := cmd.Output()
+		Expect(err).To(BeNil())
+		return string(output)
+	}
+
+	It("escapes values and expands ${NAME} references at launch", func() {
+		contents, err := libbuildpack.NewProfileScript("greeting").
+			Export("GREETING", `it's "$HOME" \ $(date) `+"`id`").
+			PrependPath("APP_PATH", "${DEPS_DIR}/0/bin").
+			AppendPath("LD_LIBRARY_PATH", "${DEPS_DIR}/0/lib").
+			SetDefault("MODE", "production").
+			Contents()
+		Expect(err).To(BeNil())
+
+		script := filepath.Join(depsDir, "greeting.sh")
+		Expect(os.MkdirAll(depsDir, 0755)).To(Succeed())
+		Expect(os.WriteFile(script, []byte(contents), 0644)).To(Succeed())
+
+		Expect(source([]string{"DEPS_DIR=/home/vcap/deps", "APP_PATH=/usr/bin"}, script)).To(Equal(
+			`it's "$HOME" \ $(date) ` + "`id`" + `|/home/vcap/deps/0/bin:/usr/bin|/home/vcap/deps/0/lib|production`))
+		Expect(source([]string{"DEPS_DIR=/d", "LD_LIBRARY_PATH=/lib", "MODE=dev"}, script)).To(Equal(
+			`it's "$HOME" \ $(date) ` + "`id`" + `|/d/0/bin|/lib:/d/0/lib|dev`))
+	})
+
+	Describe("ExportCredential", func() {
+		var script string
+
+		BeforeEach(func() {
+			contents, err := libbuildpack.NewProfileScript("credentials").
+				ExportCredential("LICENSE_KEY", "monitoring", "licenseKey", "license_key").
+				ExportCredential("REPLICA", "monitoring", "hosts.1.name").
+				ExportCredential("MISSING", "monitoring", "absent").
+				Contents()
+			Expect(err).To(BeNil())
+			script = filepath.Join(profileDir, "credentials.sh")
+			Expect(os.MkdirAll(profileDir, 0755)).To(Succeed())
+			Expect(os.WriteFile(script, []byte(contents), 0644)).To(Succeed())
+		})
+
+		launch := func(env ...string) string {
+			cmd := exec.Command("sh", "-c", `. "$1" && printf '%s|%s|%s' "$LICENSE_KEY" "$REPLICA" "${MISSING-unset}"`, "--", script)
+			cmd.Env = append(env, "PATH="+os.Getenv("PATH"))
+			output, err := cmd.Output()
+			Expect(err).To(BeNil())
+			return string(output)
+		}
+
+		It("reads credentials from the bindings under SERVICE_BINDING_ROOT", func() {
+			root := filepath.Join(depsDir, "bindings")
+			Expect(os.MkdirAll(filepath.Join(root, "monitoring"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(root, "monitoring", "license_key"), []byte("it's a secret"), 0644)).To(Succeed())
+
+			Expect(launch("SERVICE_BINDING_ROOT=" + root)).To(Equal("it's a secret||unset"))
+		})
+
+		It("reads credentials from VCAP_SERVICES", func() {
+			if _, err := exec.LookPath("jq"); err != nil {
+				Skip("needs jq")
+			}
+			vcapServices, err := libbuildpack.NewVCAPServices(
+				libbuildpack.Service{Name: "other", Label: "newrelic", Credentials: map[string]interface{}{"licenseKey": "not this one"}},
+				libbuildpack.Service{Name: "monitoring", Label: "newrelic", Credentials: map[string]interface{}{
+					"license_key": 12345678,
+					"hosts":       []interface{}{map[string]interface{}{"name": "primary"}, map[string]interface{}{"name": "replica"}},
+				}},
+			)
+			Expect(err).To(BeNil())
+
+			Expect(launch("VCAP_SERVICES=" + vcapServices)).To(Equal("12345678|replica|unset"))
+		})
+
+		It("rejects names it cannot render", func() {
+			_, err := libbuildpack.NewProfileScript("credentials").ExportCredential("KEY", `bad"name`, "key").Contents()
+			Expect(err).To(MatchError(ContainSubstring("invalid service or credential name")))
+
+			_, err = libbuildpack.NewProfileScript("credentials").ExportCredential("KEY", "monitoring").Contents()
+			Expect(err).To(MatchError("no credential paths for KEY"))
+		})
+	})
+
+	It("sources other scripts", func() {
+		contents, err := libbuildpack.NewProfileScript("source").Source("${DEPS_DIR}/0/env.sh").Contents()
+		Expect(err).To(BeNil())
+		Expect(contents).To(Equal(`. "${DEPS_DIR}"'/0/env.sh'` + "\n"))
+	})
+
+	Describe("validation", func() {
+		It("rejects invalid variable names", func() {
+			_, err := libbuildpack.NewProfileScript("bad").Export("NOT-VALID", "x").Contents()
+			Expect(err).To(MatchError("invalid environment variable name NOT-VALID"))
+		})
+
+		It("rejects invalid script names and weights", func() {
+			_, err := libbuildpack.NewProfileScript("../escape").Contents()
+			Expect(err).To(MatchError(ContainSubstring("invalid profile script name")))
+
+			_, err = libbuildpack.NewProfileScript("heavy").Weight(1000).Contents()
+			Expect(err).To(MatchError("profile script weight 1000 is not between 0 and 999"))
+		})
+
+		It("lints raw lines for unescaped interpolation", func() {
+			_, err := libbuildpack.NewProfileScript("raw").Raw(`export PATH="$PATH:/opt/bin"`).Contents()
+			Expect(err).To(BeNil())
+
+			_, err = libbuildpack.NewProfileScript("raw").Raw(`export PATH=$PATH:/opt/bin`).Contents()
+			Expect(err).To(MatchError(ContainSubstring("unquoted expansion")))
+
+			_, err = libbuildpack.NewProfileScript("raw").Raw(`export NOW="$(date)"`).Contents()
+			Expect(err).To(MatchError(ContainSubstring("command substitution")))
+
+			_, err = libbuildpack.NewProfileScript("raw").Raw("export NOW=`date`").Contents()
+			Expect(err).To(MatchError(ContainSubstring("command substitution")))
+		})
+
+		It("ignores interpolation inside single quotes and comments", func() {
+			Expect(libbuildpack.LintProfileScript("export PRICE='$(5)'\n# uses $PATH\n")).To(Succeed())
+		})
+	})
+
+	Describe("WriteProfileScript", func() {
+		It("writes unweighted scripts with WriteProfileD", func() {
+			s := stagerFor("0")
+			Expect(s.WriteProfileScript(libbuildpack.NewProfileScript("plain").Export("MODE", "x"))).To(Succeed())
+			Expect(filepath.Join(depsDir, "0", "profile.d", "plain.sh")).To(BeARegularFile())
+		})
+
+		It("orders weighted scripts by weight across buildpacks", func() {
+			Expect(stagerFor("0").WriteProfileScript(libbuildpack.NewProfileScript("late").Weight(900).Export("MODE", "late"))).To(Succeed())
+			Expect(stagerFor("1").WriteProfileScript(libbuildpack.NewProfileScript("early").Weight(50).Export("MODE", "early"))).To(Succeed())
+			Expect(stagerFor("1").WriteProfileScript(libbuildpack.NewProfileScript("plain").Export("MODE", "plain"))).To(Succeed())
+			Expect(stagerFor("10").WriteProfileScript(libbuildpack.NewProfileScript("plain").Export("MODE", "plain"))).To(Succeed())
+			Expect(stagerFor("2").WriteProfileScript(libbuildpack.NewProfileScript("middle").Weight(100).Export("MODE", "middle"))).To(Succeed())
+
+			Expect(stagerFor("1").SetLaunchEnvironment()).To(Succeed())
+
+			entries, err := os.ReadDir(profileDir)
+			Expect(err).To(BeNil())
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			Expect(names).To(Equal([]string{"000_multi-supply.sh", "050_001_early.sh", "100_002_middle.sh", "500_001_plain.sh", "500_010_plain.sh", "900_000_late.sh"}))
+		})
+	})
+})
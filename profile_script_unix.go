@@ -0,0 +1,137 @@
+// +build !windows
+
+package libbuildpack
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const profileScriptExt = ".sh"
+
+func renderProfileOp(op profileOp) string {
+	value := renderProfileValue(op.value)
+
+	switch op.kind {
+	case profileExport:
+		return fmt.Sprintf("export %s=%s", op.name, value)
+	case profileSetDefault:
+		return fmt.Sprintf(`if [ -z "${%[1]s:-}" ]; then export %[1]s=%[2]s; fi`, op.name, value)
+	case profilePrependPath:
+		return fmt.Sprintf(`export %[1]s=%[2]s"${%[1]s:+:${%[1]s}}"`, op.name, value)
+	case profileAppendPath:
+		return fmt.Sprintf(`export %[1]s="${%[1]s:+${%[1]s}:}"%[2]s`, op.name, value)
+	case profileSource:
+		return fmt.Sprintf(". %s", value)
+	case profileCredential:
+		return renderCredentialOp(op)
+	}
+	return op.raw
+}
+
+// renderCredentialOp reads the credential into BP_CREDENTIAL from the binding
+// files of the service, then from VCAP_SERVICES.
+func renderCredentialOp(op profileOp) string {
+	lines := []string{"BP_CREDENTIAL="}
+	cond := "if"
+	for _, path := range op.paths {
+		if strings.Contains(path, ".") {
+			continue
+		}
+		file := `"$SERVICE_BINDING_ROOT"/` + shellQuote(op.service) + "/" + shellQuote(path)
+		lines = append(lines, fmt.Sprintf(`%s [ -n "${SERVICE_BINDING_ROOT:-}" ] && [ -f %s ]; then BP_CREDENTIAL="$(cat %s)"`, cond, file, file))
+		cond = "elif"
+	}
+
+	var lookups []string
+	for _, path := range op.paths {
+		lookups = append(lookups, "(try "+jqCredentialPath(path)+")")
+	}
+	program := fmt.Sprintf(`first(.[][] | select(.name == $name) | .credentials | %s | select(type == "string" or type == "number" or type == "boolean") | tostring)`, strings.Join(lookups, ", "))
+	lines = append(lines,
+		fmt.Sprintf(`%s command -v jq >/dev/null 2>&1; then BP_CREDENTIAL="$(printf '%%s' "${VCAP_SERVICES:-}" | jq -r --arg name %s %s 2>/dev/null)"`, cond, shellQuote(op.service), shellQuote(program)),
+		"fi",
+		fmt.Sprintf(`if [ -n "$BP_CREDENTIAL" ]; then export %s="$BP_CREDENTIAL"; fi`, op.name),
+		"unset BP_CREDENTIAL",
+	)
+	return strings.Join(lines, "\n")
+}
+
+// jqCredentialPath turns a dot separated credential path into a jq path.
+func jqCredentialPath(path string) string {
+	var expr strings.Builder
+	expr.WriteString(".")
+	for _, key := range strings.Split(path, ".") {
+		if idx, err := strconv.Atoi(key); err == nil && idx >= 0 {
+			fmt.Fprintf(&expr, "[%d]", idx)
+		} else {
+			quoted, _ := json.Marshal(key)
+			fmt.Fprintf(&expr, "[%s]", quoted)
+		}
+	}
+	return expr.String()
+}
+
+func renderProfileValue(parts []profileValuePart) string {
+	var rendered strings.Builder
+	for _, part := range parts {
+		if part.ref {
+			rendered.WriteString(`"${` + part.text + `}"`)
+		} else {
+			rendered.WriteString(shellQuote(part.text))
+		}
+	}
+	return rendered.String()
+}
+
+// LintProfileScript refuses profile.d scripts with command substitutions or
+// expansions outside double quotes, which word split and glob at launch.
+func LintProfileScript(contents string) error {
+	for num, line := range strings.Split(contents, "\n") {
+		if err := lintProfileLine(line); err != nil {
+			return fmt.Errorf("line %d: %v: %s", num+1, err, line)
+		}
+	}
+	return nil
+}
+
+func lintProfileLine(line string) error {
+	inSingle, inDouble := false, false
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case inSingle:
+			if c == '\'' {
+				inSingle = false
+			}
+		case c == '\\':
+			i++
+		case c == '#' && !inDouble && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return nil
+		case c == '\'' && !inDouble:
+			inSingle = true
+		case c == '"':
+			inDouble = !inDouble
+		case c == '`':
+			return fmt.Errorf("command substitution")
+		case c == '$' && i+1 < len(line):
+			next := line[i+1]
+			if next == '(' {
+				return fmt.Errorf("command substitution")
+			}
+			if !inDouble && (next == '{' || next == '_' || isAlphaNum(next)) {
+				return fmt.Errorf("unquoted expansion")
+			}
+		}
+	}
+
+	return nil
+}
+
+func isAlphaNum(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
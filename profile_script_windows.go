@@ -0,0 +1,94 @@
+// +build windows
+
+package libbuildpack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const profileScriptExt = ".bat"
+
+func renderProfileOp(op profileOp) string {
+	self := profileValuePart{text: op.name, ref: true}
+	sep := profileValuePart{text: ";"}
+
+	switch op.kind {
+	case profileExport:
+		return "set " + batchSetArg(op.name, op.value...)
+	case profileSetDefault:
+		return fmt.Sprintf(`if not defined %s set %s`, op.name, batchSetArg(op.name, op.value...))
+	case profilePrependPath:
+		parts := append(append([]profileValuePart{}, op.value...), sep, self)
+		return fmt.Sprintf(`if defined %s (set %s) else (set %s)`, op.name, batchSetArg(op.name, parts...), batchSetArg(op.name, op.value...))
+	case profileAppendPath:
+		parts := append([]profileValuePart{self, sep}, op.value...)
+		return fmt.Sprintf(`if defined %s (set %s) else (set %s)`, op.name, batchSetArg(op.name, parts...), batchSetArg(op.name, op.value...))
+	case profileSource:
+		return fmt.Sprintf(`call "%s"`, renderProfileValue(op.value))
+	case profileCredential:
+		return renderCredentialOp(op)
+	}
+	return op.raw
+}
+
+// renderCredentialOp reads the credential into BP_CREDENTIAL from the binding
+// files of the service, then from VCAP_SERVICES.
+func renderCredentialOp(op profileOp) string {
+	batchEscape := strings.NewReplacer("%", "%%").Replace
+	psQuote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+
+	lines := []string{`set "BP_CREDENTIAL="`}
+	for _, path := range op.paths {
+		if strings.Contains(path, ".") {
+			continue
+		}
+		file := `"%SERVICE_BINDING_ROOT%\` + batchEscape(op.service) + `\` + batchEscape(path) + `"`
+		lines = append(lines, fmt.Sprintf(`if defined SERVICE_BINDING_ROOT if not defined BP_CREDENTIAL if exist %[1]s set /p BP_CREDENTIAL=<%[1]s`, file))
+	}
+
+	var lookups []string
+	for _, path := range op.paths {
+		lookup := "$c"
+		for _, key := range strings.Split(path, ".") {
+			if idx, err := strconv.Atoi(key); err == nil && idx >= 0 {
+				lookup += fmt.Sprintf("[%d]", idx)
+			} else {
+				lookup += "." + psQuote(key)
+			}
+		}
+		lookups = append(lookups, lookup)
+	}
+	program := fmt.Sprintf(`$c = ($env:VCAP_SERVICES | ConvertFrom-Json).PSObject.Properties.Value | ForEach-Object { $_ } | Where-Object { $_.name -eq %s } | Select-Object -First 1 -ExpandProperty credentials; @(%s) | Where-Object { $_ -ne $null } | Select-Object -First 1`, psQuote(op.service), strings.Join(lookups, ", "))
+
+	return strings.Join(append(lines,
+		fmt.Sprintf("if not defined BP_CREDENTIAL for /f \"usebackq delims=\" %%%%v in (`powershell -NoProfile -Command \"%s\"`) do set \"BP_CREDENTIAL=%%%%v\"", batchEscape(program)),
+		fmt.Sprintf(`if defined BP_CREDENTIAL set "%s=%%BP_CREDENTIAL%%"`, op.name),
+		`set "BP_CREDENTIAL="`,
+	), "\n")
+}
+
+// renderProfileValue renders a path to call. Windows paths cannot contain
+// double quotes, so only percent signs are escaped.
+func renderProfileValue(parts []profileValuePart) string {
+	var rendered strings.Builder
+	for _, part := range parts {
+		if part.ref {
+			rendered.WriteString("%" + part.text + "%")
+		} else {
+			rendered.WriteString(strings.ReplaceAll(part.text, "%", "%%"))
+		}
+	}
+	return rendered.String()
+}
+
+// LintProfileScript refuses profile.d scripts with command substitutions or
+// unquoted expansions. Batch files have neither, so every script passes.
+func LintProfileScript(contents string) error {
+	return nil
+}
+
+func lintProfileLine(line string) error {
+	return nil
+}
@@ -0,0 +1,226 @@
+package libbuildpack
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// redactedText replaces secrets, like the userinfo removed by filterURI.
+const redactedText = "-redacted-"
+
+// minSecretLength is the length below which registered secrets are ignored,
+// so that short values like ports and flags are not masked everywhere.
+const minSecretLength = 8
+
+// secretNameRe matches the names of env vars and service credentials that
+// hold secrets. Names with key only match if they end in it as a word, e.g.
+// key, API_KEY, account-access-key or licenseKey, but not KEYBOARD or
+// CACHE_KEY_PREFIX.
+var secretNameRe = regexp.MustCompile(`(?i:password|passwd|secret|token|credential|signature)|(?i:^key|[_.-]key)$|[a-z0-9]Key$`)
+
+// secretPatterns match secrets that need not be registered: URL userinfo,
+// signatures and tokens in query strings, and bearer tokens.
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`(?i)\b([a-z][a-z0-9+.-]*://)[^/\s:@]+:[^/\s@]+@`), "${1}" + redactedText + ":" + redactedText + "@"},
+	{regexp.MustCompile(`(?i)([?&](?:x-amz-signature|x-amz-credential|x-amz-security-token|x-goog-signature|x-goog-credential|signature|sig|token|access_token)=)[^&\s"'#]+`), "${1}" + redactedText},
+	{regexp.MustCompile(`(?i)(authorization:\s*(?:bearer|basic)\s+)\S+`), "${1}" + redactedText},
+	{regexp.MustCompile(`\b(Bearer\s+)[A-Za-z0-9._~+/-]{16,}=*`), "${1}" + redactedText},
+}
+
+// Redactor masks registered secret values and known secret patterns in text.
+type Redactor struct {
+	lock    sync.RWMutex
+	secrets []string
+}
+
+var globalRedactor = NewRedactor()
+
+func NewRedactor() *Redactor {
+	return &Redactor{}
+}
+
+// AddSecret registers secret to be masked. Secrets shorter than 8 characters
+// are ignored.
+func (r *Redactor) AddSecret(secret string) {
+	secret = strings.TrimSpace(secret)
+	if len(secret) < minSecretLength {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, existing := range r.secrets {
+		if existing == secret {
+			return
+		}
+	}
+	r.secrets = append(r.secrets, secret)
+	// Longer secrets go first, so that secrets containing others are masked
+	// as a whole.
+	sort.SliceStable(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+}
+
+func (r *Redactor) Clear() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.secrets = nil
+}
+
+// secretPrefixLen returns the length of the longest end of data that starts,
+// but is not all of, a registered secret.
+func (r *Redactor) secretPrefixLen(data []byte) int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	longest := 0
+	for _, secret := range r.secrets {
+		for n := len(secret) - 1; n > longest; n-- {
+			if n <= len(data) && bytes.HasSuffix(data, []byte(secret[:n])) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
+
+func (r *Redactor) Redact(text string) string {
+	r.lock.RLock()
+	for _, secret := range r.secrets {
+		text = strings.ReplaceAll(text, secret, redactedText)
+	}
+	r.lock.RUnlock()
+
+	for _, pattern := range secretPatterns {
+		text = pattern.re.ReplaceAllString(text, pattern.repl)
+	}
+	return text
+}
+
+// Writer returns a writer that writes to w with secrets masked. Complete lines
+// are written as soon as they end, and partial lines right away too, except
+// for a trailing start of a registered secret, which is held back until the
+// next write shows whether it is one, or the writer is closed. Patterns are
+// masked within each line or write.
+func (r *Redactor) Writer(w io.Writer) io.WriteCloser {
+	return &redactWriter{w: w, redactor: r, buffered: true}
+}
+
+// AddServiceSecrets registers the credentials of services whose names look
+// like they hold secrets, e.g. password or apiKey.
+func (r *Redactor) AddServiceSecrets(services Services) {
+	for _, service := range services {
+		r.addCredentialSecrets("", service.Credentials)
+	}
+}
+
+// AddEnvSecrets registers the values of env vars whose names look like they
+// hold secrets, e.g. GITHUB_TOKEN.
+func (r *Redactor) AddEnvSecrets() {
+	for _, env := range os.Environ() {
+		name, value, found := strings.Cut(env, "=")
+		if found && secretNameRe.MatchString(name) {
+			r.AddSecret(value)
+		}
+	}
+}
+
+func (r *Redactor) addCredentialSecrets(name string, value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range value {
+			r.addCredentialSecrets(key, nested)
+		}
+	case []interface{}:
+		for _, nested := range value {
+			r.addCredentialSecrets(name, nested)
+		}
+	case string:
+		if secretNameRe.MatchString(name) {
+			r.AddSecret(value)
+		}
+	}
+}
+
+// AddSecret registers secret with the redactor that Loggers and Commands use
+// unless they are given their own.
+func AddSecret(secret string) {
+	globalRedactor.AddSecret(secret)
+}
+
+func ClearSecrets() {
+	globalRedactor.Clear()
+}
+
+// Redact masks secrets in text with the package wide redactor.
+func Redact(text string) string {
+	return globalRedactor.Redact(text)
+}
+
+// RegisterStagingSecrets registers the secrets in env vars and in the
+// credentials of the bound services with the redactor of logger. NewStager
+// calls it for the logger of the Stager.
+func RegisterStagingSecrets(logger *Logger) {
+	redactor := logger.Redactor()
+	redactor.AddEnvSecrets()
+
+	services, err := LoadServices()
+	if err != nil {
+		logger.Debug("Unable to load services to redact their credentials: %v", err)
+		return
+	}
+	redactor.AddServiceSecrets(services)
+}
+
+type redactWriter struct {
+	lock     sync.Mutex
+	w        io.Writer
+	redactor *Redactor
+	buffered bool
+	partial  []byte
+}
+
+func (w *redactWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if !w.buffered {
+		_, err := io.WriteString(w.w, w.redactor.Redact(string(p)))
+		return len(p), err
+	}
+
+	data := append(w.partial, p...)
+	end := bytes.LastIndexAny(data, "\r\n") + 1
+	if end < len(data) {
+		end = len(data) - w.redactor.secretPrefixLen(data[end:])
+	}
+
+	w.partial = append([]byte{}, data[end:]...)
+	if end == 0 {
+		return len(p), nil
+	}
+	_, err := io.WriteString(w.w, w.redactor.Redact(string(data[:end])))
+	return len(p), err
+}
+
+// Close writes the partial line held back, if any.
+func (w *redactWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.partial) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.w, w.redactor.Redact(string(w.partial)))
+	w.partial = nil
+	return err
+}
@@ -0,0 +1,163 @@
+package libbuildpack
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Release is what bin/release reports to the platform: the start command of
+// each process type and config vars. Sidecars run next to the listed process
+// types and are declared to the platform through <DepDir>/launch.yml.
+type Release struct {
+	ProcessTypes map[string]string `yaml:"default_process_types"`
+	ConfigVars   map[string]string `yaml:"config_vars,omitempty"`
+	Sidecars     []Sidecar         `yaml:"-"`
+}
+
+type Sidecar struct {
+	Name         string
+	Command      string
+	ProcessTypes []string
+	MemoryMB     int
+}
+
+type launchYml struct {
+	Processes []launchProcess `yaml:"processes"`
+}
+
+type launchProcess struct {
+	Type      string          `yaml:"type"`
+	Command   string          `yaml:"command"`
+	Limits    *launchLimits   `yaml:"limits,omitempty"`
+	Platforms launchPlatforms `yaml:"platforms"`
+}
+
+type launchLimits struct {
+	Memory int `yaml:"memory"`
+}
+
+type launchPlatforms struct {
+	CloudFoundry struct {
+		SidecarFor []string `yaml:"sidecar_for"`
+	} `yaml:"cloudfoundry"`
+}
+
+var processTypeRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Validate checks that every process type and sidecar is named and has a
+// command, and that sidecars are attached to at least one process type.
+func (r *Release) Validate() error {
+	for name, command := range r.ProcessTypes {
+		if !processTypeRe.MatchString(name) {
+			return fmt.Errorf("invalid process type name %q", name)
+		}
+		if command == "" {
+			return fmt.Errorf("process type %s has no command", name)
+		}
+	}
+
+	sidecars := map[string]bool{}
+	for _, sidecar := range r.Sidecars {
+		if !processTypeRe.MatchString(sidecar.Name) {
+			return fmt.Errorf("invalid sidecar name %q", sidecar.Name)
+		}
+		if sidecars[sidecar.Name] {
+			return fmt.Errorf("sidecar %s is declared more than once", sidecar.Name)
+		}
+		sidecars[sidecar.Name] = true
+
+		if sidecar.Command == "" {
+			return fmt.Errorf("sidecar %s has no command", sidecar.Name)
+		}
+		if len(sidecar.ProcessTypes) == 0 {
+			return fmt.Errorf("sidecar %s is not attached to any process type", sidecar.Name)
+		}
+		if sidecar.MemoryMB < 0 {
+			return fmt.Errorf("sidecar %s has a negative memory limit", sidecar.Name)
+		}
+	}
+
+	return nil
+}
+
+// ReleaseYmlPath is where WriteRelease stores the release of the buildpack
+// for language, and where PrintRelease reads it from.
+func ReleaseYmlPath(buildDir, language string) string {
+	return filepath.Join(buildDir, "tmp", language+"-buildpack-release-step.yml")
+}
+
+// WriteRelease runs the BeforeRelease hooks, then validates release and
+// stores it for bin/release to print. Sidecars are written to
+// <DepDir>/launch.yml.
+func (s *Stager) WriteRelease(release *Release) error {
+	if err := RunBeforeRelease(s, release); err != nil {
+		return err
+	}
+
+	if err := release.Validate(); err != nil {
+		return err
+	}
+
+	if release.ProcessTypes == nil {
+		release.ProcessTypes = map[string]string{}
+	}
+
+	y := NewYAML()
+	write := y.Write
+	if Planning() {
+		write = s.manifest.planWriteYAML
+	}
+	if err := write(ReleaseYmlPath(s.buildDir, s.manifest.Language()), release); err != nil {
+		return err
+	}
+
+	if len(release.Sidecars) == 0 {
+		return nil
+	}
+
+	var launch launchYml
+	for _, sidecar := range release.Sidecars {
+		process := launchProcess{Type: sidecar.Name, Command: sidecar.Command}
+		if sidecar.MemoryMB > 0 {
+			process.Limits = &launchLimits{Memory: sidecar.MemoryMB}
+		}
+		process.Platforms.CloudFoundry.SidecarFor = sidecar.ProcessTypes
+		launch.Processes = append(launch.Processes, process)
+	}
+
+	return write(filepath.Join(s.DepDir(), "launch.yml"), launch)
+}
+
+// PrintRelease writes the release stored in buildDir by WriteRelease to w,
+// in the YAML format the platform expects from bin/release.
+func PrintRelease(buildDir, language string, w io.Writer) error {
+	var release Release
+	y := NewYAML()
+	if err := y.Load(ReleaseYmlPath(buildDir, language), &release); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no release was written to %s", ReleaseYmlPath(buildDir, language))
+		}
+		return err
+	}
+
+	if err := release.Validate(); err != nil {
+		return err
+	}
+
+	if release.ProcessTypes == nil {
+		release.ProcessTypes = map[string]string{}
+	}
+
+	data, err := yaml.Marshal(&release)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "---\n%s", data)
+	return err
+}
@@ -0,0 +1,183 @@
+package libbuildpack_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Release", func() {
+	var (
+		buildDir string
+		depsDir  string
+		s        *libbuildpack.Stager
+		release  *libbuildpack.Release
+		err      error
+	)
+
+	BeforeEach(func() {
+		buildDir, err = os.MkdirTemp("", "build")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, buildDir)
+
+		depsDir, err = os.MkdirTemp("", "deps")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, depsDir)
+
+		logger := libbuildpack.NewLogger(new(bytes.Buffer))
+		manifest, err := libbuildpack.NewManifest(filepath.Join("fixtures", "manifest", "standard"), logger, time.Now())
+		Expect(err).To(BeNil())
+
+		s = libbuildpack.NewStager([]string{buildDir, "", depsDir, "0"}, logger, manifest)
+
+		release = &libbuildpack.Release{
+			ProcessTypes: map[string]string{"web": "dotnet app.dll", "worker": "dotnet worker.dll"},
+			ConfigVars:   map[string]string{"ASPNETCORE_ENVIRONMENT": "Production"},
+			Sidecars: []libbuildpack.Sidecar{
+				{Name: "envoy", Command: "envoy -c envoy.yml", ProcessTypes: []string{"web"}, MemoryMB: 64},
+				{Name: "logs", Command: "./ship-logs", ProcessTypes: []string{"web", "worker"}},
+			},
+		}
+	})
+
+	Describe("Validate", func() {
+		It("accepts a valid release", func() {
+			Expect(release.Validate()).To(Succeed())
+		})
+
+		It("rejects process types without a command", func() {
+			release.ProcessTypes["web"] = ""
+			Expect(release.Validate()).To(MatchError("process type web has no command"))
+		})
+
+		It("rejects invalid process type names", func() {
+			release.ProcessTypes["my web"] = "run"
+			Expect(release.Validate()).To(MatchError(`invalid process type name "my web"`))
+		})
+
+		It("rejects sidecars without process types", func() {
+			release.Sidecars[1].ProcessTypes = nil
+			Expect(release.Validate()).To(MatchError("sidecar logs is not attached to any process type"))
+		})
+
+		It("rejects duplicate sidecars", func() {
+			release.Sidecars[1].Name = "envoy"
+			Expect(release.Validate()).To(MatchError("sidecar envoy is declared more than once"))
+		})
+
+		It("rejects negative memory limits", func() {
+			release.Sidecars[0].MemoryMB = -1
+			Expect(release.Validate()).To(MatchError("sidecar envoy has a negative memory limit"))
+		})
+	})
+
+	Describe("WriteRelease", func() {
+		It("writes the release to the tmp release location", func() {
+			Expect(s.WriteRelease(release)).To(Succeed())
+
+			var written map[string]map[string]string
+			Expect(libbuildpack.NewYAML().Load(libbuildpack.ReleaseYmlPath(buildDir, "dotnet-core"), &written)).To(Succeed())
+			Expect(written["default_process_types"]).To(Equal(map[string]string{"web": "dotnet app.dll", "worker": "dotnet worker.dll"}))
+			Expect(written["config_vars"]).To(Equal(map[string]string{"ASPNETCORE_ENVIRONMENT": "Production"}))
+		})
+
+		It("writes sidecars to <depDir>/launch.yml", func() {
+			Expect(s.WriteRelease(release)).To(Succeed())
+
+			Expect(os.ReadFile(filepath.Join(depsDir, "0", "launch.yml"))).To(MatchYAML(`
+processes:
+- type: envoy
+  command: envoy -c envoy.yml
+  limits:
+    memory: 64
+  platforms:
+    cloudfoundry:
+      sidecar_for: [web]
+- type: logs
+  command: ./ship-logs
+  platforms:
+    cloudfoundry:
+      sidecar_for: [web, worker]
+`))
+		})
+
+		It("does not write launch.yml without sidecars", func() {
+			release.Sidecars = nil
+			Expect(s.WriteRelease(release)).To(Succeed())
+			Expect(filepath.Join(depsDir, "0", "launch.yml")).NotTo(BeAnExistingFile())
+		})
+
+		It("does not write an invalid release", func() {
+			release.ProcessTypes["web"] = ""
+			Expect(s.WriteRelease(release)).NotTo(Succeed())
+			Expect(libbuildpack.ReleaseYmlPath(buildDir, "dotnet-core")).NotTo(BeAnExistingFile())
+		})
+
+		It("lets BeforeRelease hooks change the release", func() {
+			s.Hooks().Add(addProcessHook{})
+			Expect(s.WriteRelease(release)).To(Succeed())
+
+			var written libbuildpack.Release
+			Expect(libbuildpack.NewYAML().Load(libbuildpack.ReleaseYmlPath(buildDir, "dotnet-core"), &written)).To(Succeed())
+			Expect(written.ProcessTypes).To(HaveKeyWithValue("migrate", "dotnet ef database update"))
+		})
+	})
+
+	Describe("PrintRelease", func() {
+		var buffer *bytes.Buffer
+
+		BeforeEach(func() {
+			buffer = new(bytes.Buffer)
+		})
+
+		It("prints the stored release", func() {
+			Expect(s.WriteRelease(release)).To(Succeed())
+			Expect(libbuildpack.PrintRelease(buildDir, "dotnet-core", buffer)).To(Succeed())
+
+			Expect(buffer.String()).To(HavePrefix("---\n"))
+			Expect(buffer.String()).To(MatchYAML(`
+default_process_types:
+  web: dotnet app.dll
+  worker: dotnet worker.dll
+config_vars:
+  ASPNETCORE_ENVIRONMENT: Production
+`))
+		})
+
+		It("prints empty process types when there are none", func() {
+			Expect(s.WriteRelease(&libbuildpack.Release{})).To(Succeed())
+			Expect(libbuildpack.PrintRelease(buildDir, "dotnet-core", buffer)).To(Succeed())
+			Expect(buffer.String()).To(Equal("---\ndefault_process_types: {}\n"))
+		})
+
+		It("returns an error when no release was written", func() {
+			err := libbuildpack.PrintRelease(buildDir, "dotnet-core", buffer)
+			Expect(err).To(MatchError(ContainSubstring("no release was written to")))
+			Expect(buffer.String()).To(BeEmpty())
+		})
+
+		It("validates the stored release", func() {
+			releaseYml := libbuildpack.ReleaseYmlPath(buildDir, "dotnet-core")
+			Expect(os.MkdirAll(filepath.Dir(releaseYml), 0755)).To(Succeed())
+			Expect(os.WriteFile(releaseYml, []byte("default_process_types:\n  web: ''\n"), 0644)).To(Succeed())
+
+			Expect(libbuildpack.PrintRelease(buildDir, "dotnet-core", buffer)).To(MatchError("process type web has no command"))
+			Expect(buffer.String()).To(BeEmpty())
+		})
+	})
+})
+
+type addProcessHook struct {
+	libbuildpack.DefaultHook
+}
+
+func (addProcessHook) BeforeRelease(_ *libbuildpack.Stager, release *libbuildpack.Release) error {
+	release.ProcessTypes["migrate"] = "dotnet ef database update"
+	return nil
+}
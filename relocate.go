@@ -0,0 +1,263 @@
+package libbuildpack
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Relocation describes how an installed dependency that was compiled with an
+// absolute build prefix is rewritten to point at its real install location.
+//
+//	relocation:
+//	  prefix: /tmp/build/ruby
+//	  files:
+//	  - lib/pkgconfig/*.pc
+//	  runpath: true
+//
+// Shebang lines naming an interpreter under prefix are always rewritten.
+// Other text files are only rewritten when they match one of the files
+// globs, which are relative to the install directory. When runpath is set,
+// the DT_RUNPATH and DT_RPATH entries of ELF files are patched in place;
+// as the string table cannot grow, the new path must not be longer than the
+// one recorded at build time, so dependencies should be built with a
+// generously long prefix.
+type Relocation struct {
+	Prefix  string   `yaml:"prefix"`
+	Files   []string `yaml:"files"`
+	RunPath bool     `yaml:"runpath"`
+}
+
+const sniffLen = 8000
+
+var elfMagic = []byte(elf.ELFMAG)
+
+// RelocateDependency applies the relocation rules declared for dep in the
+// manifest to the files in installDir. It does nothing if none are declared.
+func (i *Installer) RelocateDependency(dep Dependency, installDir string) error {
+	entry, err := i.manifest.GetEntry(dep)
+	if err != nil {
+		return err
+	}
+
+	return i.relocate(entry, installDir)
+}
+
+func (i *Installer) relocate(entry *ManifestEntry, installDir string) error {
+	if entry.Relocation == nil || entry.Relocation.Prefix == "" {
+		return nil
+	}
+
+	installDir, err := filepath.Abs(installDir)
+	if err != nil {
+		return err
+	}
+
+	r := entry.Relocation
+	prefix := strings.TrimRight(r.Prefix, "/")
+	i.manifest.log.Debug("Relocating %s %s from %s to %s", entry.Dependency.Name, entry.Dependency.Version, prefix, installDir)
+
+	return filepath.Walk(installDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(installDir, path)
+		if err != nil {
+			return err
+		}
+
+		head, err := readHead(path, sniffLen)
+		if err != nil {
+			return err
+		}
+
+		if bytes.HasPrefix(head, elfMagic) {
+			if !r.RunPath {
+				return nil
+			}
+			return relocateRunPath(path, prefix, installDir)
+		}
+
+		if bytes.IndexByte(head, 0) != -1 {
+			return nil
+		}
+
+		matched, err := matchesAny(r.Files, filepath.ToSlash(relPath))
+		if err != nil {
+			return err
+		}
+
+		if matched {
+			return replaceInFile(path, info.Mode(), func(contents []byte) []byte {
+				return bytes.ReplaceAll(contents, []byte(prefix), []byte(installDir))
+			})
+		}
+
+		if bytes.HasPrefix(head, []byte("#!")) {
+			return replaceInFile(path, info.Mode(), func(contents []byte) []byte {
+				return relocateShebang(contents, prefix, installDir)
+			})
+		}
+
+		return nil
+	})
+}
+
+func readHead(path string, n int) ([]byte, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	head := make([]byte, n)
+	read, err := io.ReadFull(fh, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	return head[:read], nil
+}
+
+func matchesAny(patterns []string, relPath string) (bool, error) {
+	for _, pattern := range patterns {
+		matched, err := filepath.Match(pattern, relPath)
+		if err != nil {
+			return false, fmt.Errorf("invalid relocation file pattern %s: %v", pattern, err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func replaceInFile(path string, mode os.FileMode, replace func([]byte) []byte) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	replaced := replace(contents)
+	if bytes.Equal(contents, replaced) {
+		return nil
+	}
+
+	return writeToFile(bytes.NewReader(replaced), path, mode)
+}
+
+func relocateShebang(contents []byte, prefix, installDir string) []byte {
+	lineEnd := bytes.IndexByte(contents, '\n')
+	if lineEnd == -1 {
+		lineEnd = len(contents)
+	}
+
+	shebang := contents[:lineEnd]
+	if !bytes.Contains(shebang, []byte(prefix)) {
+		return contents
+	}
+
+	relocated := bytes.ReplaceAll(shebang, []byte(prefix), []byte(installDir))
+	return append(relocated, contents[lineEnd:]...)
+}
+
+// relocateRunPath rewrites the DT_RUNPATH and DT_RPATH strings of the ELF
+// file at path in place, padding with NUL bytes if the new value is shorter.
+func relocateRunPath(path, prefix, installDir string) error {
+	f, err := elf.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not read ELF file %s: %v", path, err)
+	}
+	defer f.Close()
+
+	dynamic := f.SectionByType(elf.SHT_DYNAMIC)
+	if dynamic == nil || int(dynamic.Link) >= len(f.Sections) {
+		return nil
+	}
+	dynstr := f.Sections[dynamic.Link]
+
+	dynData, err := dynamic.Data()
+	if err != nil {
+		return err
+	}
+	strData, err := dynstr.Data()
+	if err != nil {
+		return err
+	}
+
+	entrySize := 16
+	if f.Class == elf.ELFCLASS32 {
+		entrySize = 8
+	}
+
+	var patches []runPathPatch
+	for off := 0; off+entrySize <= len(dynData); off += entrySize {
+		var tag, val uint64
+		if f.Class == elf.ELFCLASS32 {
+			tag = uint64(f.ByteOrder.Uint32(dynData[off : off+4]))
+			val = uint64(f.ByteOrder.Uint32(dynData[off+4 : off+8]))
+		} else {
+			tag = f.ByteOrder.Uint64(dynData[off : off+8])
+			val = f.ByteOrder.Uint64(dynData[off+8 : off+16])
+		}
+
+		if elf.DynTag(tag) == elf.DT_NULL {
+			break
+		}
+		if elf.DynTag(tag) != elf.DT_RUNPATH && elf.DynTag(tag) != elf.DT_RPATH {
+			continue
+		}
+		if val >= uint64(len(strData)) {
+			return fmt.Errorf("invalid runpath offset in %s", path)
+		}
+
+		end := bytes.IndexByte(strData[val:], 0)
+		if end == -1 {
+			return fmt.Errorf("unterminated runpath in %s", path)
+		}
+
+		oldPath := string(strData[val : val+uint64(end)])
+		newPath := strings.ReplaceAll(oldPath, prefix, installDir)
+		if newPath == oldPath {
+			continue
+		}
+		if len(newPath) > len(oldPath) {
+			return fmt.Errorf("cannot relocate runpath of %s: %s is longer than the original %s", path, newPath, oldPath)
+		}
+
+		patch := make([]byte, len(oldPath))
+		copy(patch, newPath)
+		patches = append(patches, runPathPatch{offset: int64(dynstr.Offset + val), data: patch})
+	}
+
+	if len(patches) == 0 {
+		return nil
+	}
+
+	fh, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	for _, p := range patches {
+		if _, err := fh.WriteAt(p.data, p.offset); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type runPathPatch struct {
+	offset int64
+	data   []byte
+}
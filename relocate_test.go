@@ -0,0 +1,107 @@
+package libbuildpack_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	httpmock "github.com/jarcoal/httpmock"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Relocation", func() {
+	var (
+		oldCfStack string
+		installer  *libbuildpack.Installer
+		outputDir  string
+		buildPath  string
+		err        error
+	)
+
+	BeforeEach(func() {
+		oldCfStack = os.Getenv("CF_STACK")
+		os.Setenv("CF_STACK", "cflinuxfs2")
+		DeferCleanup(os.Setenv, "CF_STACK", oldCfStack)
+		httpmock.Reset()
+
+		buildPath = "/tmp/build-prefix-" + strings.Repeat("x", 120)
+
+		outputDir, err = os.MkdirTemp("", "relocate")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, outputDir)
+
+		tgzContents, err := os.ReadFile("fixtures/relocatable.tgz")
+		Expect(err).To(BeNil())
+		httpmock.RegisterResponder("GET", "https://example.com/dependencies/relocatable-1.0.0-linux-x64.tgz",
+			httpmock.NewStringResponder(200, string(tgzContents)))
+		httpmock.RegisterResponder("GET", "https://example.com/dependencies/unrelocated-1.0.0-linux-x64.tgz",
+			httpmock.NewStringResponder(200, string(tgzContents)))
+
+		manifest, err := libbuildpack.NewManifest("fixtures/manifest/relocate", libbuildpack.NewLogger(new(bytes.Buffer)), time.Now())
+		Expect(err).To(BeNil())
+		installer = libbuildpack.NewInstaller(manifest)
+	})
+
+	runPath := func(path string) string {
+		f, err := elf.Open(path)
+		Expect(err).To(BeNil())
+		defer f.Close()
+
+		paths, err := f.DynString(elf.DT_RUNPATH)
+		Expect(err).To(BeNil())
+		Expect(paths).To(HaveLen(1))
+		return paths[0]
+	}
+
+	Context("dependency declares relocation rules", func() {
+		It("rewrites the shebang of scripts", func() {
+			Expect(installer.InstallDependency(libbuildpack.Dependency{Name: "relocatable", Version: "1.0.0"}, outputDir)).To(Succeed())
+
+			Expect(os.ReadFile(filepath.Join(outputDir, "bin", "tool"))).To(Equal([]byte("#!" + outputDir + "/bin/ruby\nputs \"hello\"\n")))
+		})
+
+		It("rewrites text files matching the declared globs", func() {
+			Expect(installer.InstallDependency(libbuildpack.Dependency{Name: "relocatable", Version: "1.0.0"}, outputDir)).To(Succeed())
+
+			Expect(os.ReadFile(filepath.Join(outputDir, "etc", "tool.conf"))).To(Equal([]byte("prefix=" + outputDir + "\nlibdir=" + outputDir + "/lib\n")))
+		})
+
+		It("leaves other text files alone", func() {
+			Expect(installer.InstallDependency(libbuildpack.Dependency{Name: "relocatable", Version: "1.0.0"}, outputDir)).To(Succeed())
+
+			Expect(os.ReadFile(filepath.Join(outputDir, "etc", "README"))).To(Equal([]byte("see " + buildPath + "\n")))
+		})
+
+		It("patches the ELF runpath", func() {
+			Expect(installer.InstallDependency(libbuildpack.Dependency{Name: "relocatable", Version: "1.0.0"}, outputDir)).To(Succeed())
+
+			Expect(runPath(filepath.Join(outputDir, "lib", "liba.so"))).To(Equal(outputDir + "/lib"))
+		})
+
+		Context("the install dir is longer than the build prefix", func() {
+			BeforeEach(func() {
+				outputDir = filepath.Join(outputDir, strings.Repeat("y", 150))
+			})
+
+			It("returns an error", func() {
+				err = installer.InstallDependency(libbuildpack.Dependency{Name: "relocatable", Version: "1.0.0"}, outputDir)
+				Expect(err).To(MatchError(ContainSubstring("cannot relocate runpath")))
+			})
+		})
+	})
+
+	Context("dependency does not declare relocation rules", func() {
+		It("installs the files unchanged", func() {
+			Expect(installer.InstallDependency(libbuildpack.Dependency{Name: "unrelocated", Version: "1.0.0"}, outputDir)).To(Succeed())
+
+			Expect(os.ReadFile(filepath.Join(outputDir, "bin", "tool"))).To(Equal([]byte("#!" + buildPath + "/bin/ruby\nputs \"hello\"\n")))
+			Expect(runPath(filepath.Join(outputDir, "lib", "liba.so"))).To(Equal(buildPath + "/lib"))
+		})
+	})
+})
@@ -0,0 +1,275 @@
+package libbuildpack
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Service is a service bound to the app, from VCAP_SERVICES or a binding
+// under SERVICE_BINDING_ROOT. For bindings, Label is the type file and the
+// other files become string credentials.
+type Service struct {
+	Name         string                 `json:"name"`
+	Label        string                 `json:"label"`
+	Plan         string                 `json:"plan,omitempty"`
+	Provider     string                 `json:"provider,omitempty"`
+	Tags         []string               `json:"tags"`
+	InstanceName string                 `json:"instance_name,omitempty"`
+	BindingName  string                 `json:"binding_name,omitempty"`
+	Credentials  map[string]interface{} `json:"credentials"`
+}
+
+type Services []Service
+
+type VCAPApplication struct {
+	ApplicationID      string   `json:"application_id"`
+	ApplicationName    string   `json:"application_name"`
+	ApplicationURIs    []string `json:"application_uris"`
+	ApplicationVersion string   `json:"application_version"`
+	CFAPI              string   `json:"cf_api"`
+	Limits             struct {
+		Disk int `json:"disk"`
+		FDs  int `json:"fds"`
+		Mem  int `json:"mem"`
+	} `json:"limits"`
+	Name             string   `json:"name"`
+	OrganizationID   string   `json:"organization_id"`
+	OrganizationName string   `json:"organization_name"`
+	SpaceID          string   `json:"space_id"`
+	SpaceName        string   `json:"space_name"`
+	URIs             []string `json:"uris"`
+}
+
+// LoadServices returns the services in VCAP_SERVICES followed by the
+// bindings in SERVICE_BINDING_ROOT. Either may be unset.
+func LoadServices() (Services, error) {
+	services, err := ParseVCAPServices(os.Getenv("VCAP_SERVICES"))
+	if err != nil {
+		return nil, err
+	}
+
+	if root := os.Getenv("SERVICE_BINDING_ROOT"); root != "" {
+		bindings, err := ReadServiceBindings(root)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, bindings...)
+	}
+
+	return services, nil
+}
+
+// ParseVCAPServices parses the VCAP_SERVICES JSON, which groups services by
+// label. Services are returned sorted by label, then in their given order.
+// Numeric credentials are json.Numbers, so large integers keep every digit.
+func ParseVCAPServices(vcapServices string) (Services, error) {
+	if strings.TrimSpace(vcapServices) == "" {
+		return nil, nil
+	}
+
+	var byLabel map[string][]Service
+	decoder := json.NewDecoder(strings.NewReader(vcapServices))
+	decoder.UseNumber()
+	if err := decoder.Decode(&byLabel); err != nil {
+		return nil, fmt.Errorf("could not parse VCAP_SERVICES: %v", err)
+	}
+
+	labels := make([]string, 0, len(byLabel))
+	for label := range byLabel {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var services Services
+	for _, label := range labels {
+		for _, service := range byLabel[label] {
+			if service.Label == "" {
+				service.Label = label
+			}
+			services = append(services, service)
+		}
+	}
+
+	return services, nil
+}
+
+// NewVCAPServices returns the VCAP_SERVICES JSON for services, e.g. to set up
+// the environment in tests.
+func NewVCAPServices(services ...Service) (string, error) {
+	byLabel := map[string][]Service{}
+	for _, service := range services {
+		byLabel[service.Label] = append(byLabel[service.Label], service)
+	}
+
+	data, err := json.Marshal(byLabel)
+	return string(data), err
+}
+
+// ReadServiceBindings reads Kubernetes style service bindings: one directory
+// per binding under root, with a file per entry. Directories and files may be
+// symlinks, as in mounted secrets. Credentials are read verbatim; the type,
+// provider and tags are trimmed.
+func ReadServiceBindings(root string) (Services, error) {
+	dirs, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var services Services
+	for _, dir := range dirs {
+		if strings.HasPrefix(dir.Name(), ".") {
+			continue
+		}
+		bindingDir := filepath.Join(root, dir.Name())
+		if info, err := os.Stat(bindingDir); err != nil {
+			return nil, err
+		} else if !info.IsDir() {
+			continue
+		}
+
+		files, err := os.ReadDir(bindingDir)
+		if err != nil {
+			return nil, err
+		}
+
+		service := Service{Name: dir.Name(), BindingName: dir.Name(), Credentials: map[string]interface{}{}}
+		for _, file := range files {
+			if strings.HasPrefix(file.Name(), ".") {
+				continue
+			}
+			path := filepath.Join(bindingDir, file.Name())
+			if info, err := os.Stat(path); err != nil {
+				return nil, err
+			} else if info.IsDir() {
+				continue
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			value := string(content)
+
+			switch file.Name() {
+			case "type":
+				service.Label = strings.TrimSpace(value)
+			case "provider":
+				service.Provider = strings.TrimSpace(value)
+			case "tags":
+				service.Tags = strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' })
+			default:
+				service.Credentials[file.Name()] = value
+			}
+		}
+
+		services = append(services, service)
+	}
+
+	return services, nil
+}
+
+// LoadVCAPApplication parses VCAP_APPLICATION. It returns the zero value if
+// it is unset.
+func LoadVCAPApplication() (VCAPApplication, error) {
+	var app VCAPApplication
+
+	vcapApplication := os.Getenv("VCAP_APPLICATION")
+	if strings.TrimSpace(vcapApplication) == "" {
+		return app, nil
+	}
+
+	if err := json.Unmarshal([]byte(vcapApplication), &app); err != nil {
+		return app, fmt.Errorf("could not parse VCAP_APPLICATION: %v", err)
+	}
+	return app, nil
+}
+
+func (s Services) Filter(keep func(Service) bool) Services {
+	var services Services
+	for _, service := range s {
+		if keep(service) {
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+func (s Services) WithLabel(label string) Services {
+	return s.Filter(func(service Service) bool { return service.Label == label })
+}
+
+func (s Services) WithTag(tag string) Services {
+	return s.Filter(func(service Service) bool { return service.HasTag(tag) })
+}
+
+func (s Services) WithName(re *regexp.Regexp) Services {
+	return s.Filter(func(service Service) bool { return re.MatchString(service.Name) })
+}
+
+func (s Service) HasTag(tag string) bool {
+	for _, t := range s.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Credential looks up a credential by a dot separated path, where numeric
+// elements index into lists, e.g. "hosts.0.uri".
+func (s Service) Credential(path string) (interface{}, bool) {
+	var current interface{} = s.Credentials
+
+	for _, key := range strings.Split(path, ".") {
+		switch value := current.(type) {
+		case map[string]interface{}:
+			next, found := value[key]
+			if !found {
+				return nil, false
+			}
+			current = next
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(value) {
+				return nil, false
+			}
+			current = value[idx]
+		default:
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// CredentialString looks up a credential like Credential, formatting numbers
+// as they were written in VCAP_SERVICES, or as JSON writes them, e.g.
+// 12345678 rather than 1.2345678e+07, and booleans as true or false.
+func (s Service) CredentialString(path string) (string, bool) {
+	value, found := s.Credential(path)
+	if !found {
+		return "", false
+	}
+
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(value), true
+	default:
+		return "", false
+	}
+}
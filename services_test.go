@@ -0,0 +1,259 @@
+package libbuildpack_test
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+
+	"github.com/cloudfoundry/libbuildpack"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Services", func() {
+	var (
+		bindingRoot string
+		err         error
+	)
+
+	BeforeEach(func() {
+		for _, name := range []string{"VCAP_SERVICES", "VCAP_APPLICATION", "SERVICE_BINDING_ROOT"} {
+			DeferCleanup(os.Setenv, name, os.Getenv(name))
+			os.Unsetenv(name)
+		}
+
+		bindingRoot, err = os.MkdirTemp("", "bindings")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, bindingRoot)
+
+		vcapServices, err := libbuildpack.NewVCAPServices(
+			libbuildpack.Service{
+				Name:  "orders-db",
+				Label: "postgres",
+				Plan:  "small",
+				Tags:  []string{"relational", "sql"},
+				Credentials: map[string]interface{}{
+					"uri":   "postgres://db.example.com/orders",
+					"port":  5432,
+					"ssl":   true,
+					"hosts": []map[string]string{{"name": "primary"}, {"name": "replica"}},
+				},
+			},
+			libbuildpack.Service{
+				Name:        "newrelic",
+				Label:       "user-provided",
+				Tags:        []string{"apm"},
+				Credentials: map[string]interface{}{"licenseKey": "abc123"},
+			},
+			libbuildpack.Service{
+				Name:  "customers-db",
+				Label: "postgres",
+				Tags:  []string{"sql"},
+			},
+		)
+		Expect(err).To(BeNil())
+		os.Setenv("VCAP_SERVICES", vcapServices)
+	})
+
+	writeBinding := func(name string, files map[string]string) {
+		Expect(os.MkdirAll(filepath.Join(bindingRoot, name), 0755)).To(Succeed())
+		for file, content := range files {
+			Expect(os.WriteFile(filepath.Join(bindingRoot, name, file), []byte(content), 0644)).To(Succeed())
+		}
+	}
+
+	Describe("LoadServices", func() {
+		It("parses VCAP_SERVICES sorted by label", func() {
+			services, err := libbuildpack.LoadServices()
+			Expect(err).To(BeNil())
+
+			Expect(services).To(HaveLen(3))
+			Expect(services[0].Name).To(Equal("orders-db"))
+			Expect(services[0].Plan).To(Equal("small"))
+			Expect(services[1].Name).To(Equal("customers-db"))
+			Expect(services[2].Name).To(Equal("newrelic"))
+		})
+
+		It("fills in the label from the VCAP_SERVICES key", func() {
+			os.Setenv("VCAP_SERVICES", `{"mysql": [{"name": "db", "credentials": {}}]}`)
+
+			services, err := libbuildpack.LoadServices()
+			Expect(err).To(BeNil())
+			Expect(services).To(Equal(libbuildpack.Services{{Name: "db", Label: "mysql", Credentials: map[string]interface{}{}}}))
+		})
+
+		It("returns an error for invalid VCAP_SERVICES", func() {
+			os.Setenv("VCAP_SERVICES", `not json`)
+
+			_, err := libbuildpack.LoadServices()
+			Expect(err).To(MatchError(ContainSubstring("could not parse VCAP_SERVICES")))
+		})
+
+		It("returns no services when nothing is bound", func() {
+			os.Unsetenv("VCAP_SERVICES")
+
+			Expect(libbuildpack.LoadServices()).To(BeEmpty())
+		})
+
+		Context("SERVICE_BINDING_ROOT is set", func() {
+			BeforeEach(func() {
+				os.Setenv("SERVICE_BINDING_ROOT", bindingRoot)
+				writeBinding("redis-cache", map[string]string{
+					"type":     "redis\n",
+					"provider": "bitnami",
+					"tags":     "cache,kv",
+					"host":     "redis.svc",
+					"password": "secret\n",
+				})
+				writeBinding(".hidden", map[string]string{"type": "ignored"})
+			})
+
+			It("appends the bindings to VCAP_SERVICES", func() {
+				services, err := libbuildpack.LoadServices()
+				Expect(err).To(BeNil())
+
+				Expect(services).To(HaveLen(4))
+				Expect(services[3]).To(Equal(libbuildpack.Service{
+					Name:        "redis-cache",
+					BindingName: "redis-cache",
+					Label:       "redis",
+					Provider:    "bitnami",
+					Tags:        []string{"cache", "kv"},
+					Credentials: map[string]interface{}{"host": "redis.svc", "password": "secret\n"},
+				}))
+			})
+
+			It("follows symlinked binding dirs and files, like mounted secrets", func() {
+				if runtime.GOOS == "windows" {
+					Skip("symlinks need privileges on Windows")
+				}
+				writeBinding(".data-mq", map[string]string{"type": "rabbitmq", "password": " padded "})
+				Expect(os.Symlink(filepath.Join(bindingRoot, ".data-mq"), filepath.Join(bindingRoot, "mq"))).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(bindingRoot, "linked"), 0755)).To(Succeed())
+				Expect(os.Symlink(filepath.Join(bindingRoot, ".data-mq", "type"), filepath.Join(bindingRoot, "linked", "type"))).To(Succeed())
+
+				services, err := libbuildpack.LoadServices()
+				Expect(err).To(BeNil())
+
+				Expect(services.WithLabel("rabbitmq")).To(HaveLen(2))
+				mq := services.WithName(regexp.MustCompile(`^mq$`))
+				Expect(mq).To(HaveLen(1))
+				Expect(mq[0].Credentials).To(Equal(map[string]interface{}{"password": " padded "}))
+			})
+
+			It("can be queried like VCAP_SERVICES", func() {
+				services, err := libbuildpack.LoadServices()
+				Expect(err).To(BeNil())
+
+				password, found := services.WithLabel("redis")[0].CredentialString("password")
+				Expect(found).To(BeTrue())
+				Expect(password).To(Equal("secret\n"))
+				Expect(services.WithTag("cache")).To(HaveLen(1))
+			})
+		})
+	})
+
+	Describe("queries", func() {
+		var services libbuildpack.Services
+
+		BeforeEach(func() {
+			services, err = libbuildpack.LoadServices()
+			Expect(err).To(BeNil())
+		})
+
+		It("finds services by label", func() {
+			Expect(services.WithLabel("postgres")).To(HaveLen(2))
+			Expect(services.WithLabel("mysql")).To(BeEmpty())
+		})
+
+		It("finds services by tag", func() {
+			found := services.WithTag("apm")
+			Expect(found).To(HaveLen(1))
+			Expect(found[0].Name).To(Equal("newrelic"))
+		})
+
+		It("finds services by name", func() {
+			found := services.WithName(regexp.MustCompile(`-db$`))
+			Expect(found).To(HaveLen(2))
+			Expect(found.WithTag("relational")[0].Name).To(Equal("orders-db"))
+		})
+	})
+
+	Describe("Credential", func() {
+		var service libbuildpack.Service
+
+		BeforeEach(func() {
+			services, err := libbuildpack.LoadServices()
+			Expect(err).To(BeNil())
+			service = services[0]
+		})
+
+		credentialString := func(path string) string {
+			value, found := service.CredentialString(path)
+			Expect(found).To(BeTrue(), path)
+			return value
+		}
+
+		It("looks up nested paths", func() {
+			Expect(credentialString("hosts.1.name")).To(Equal("replica"))
+			Expect(credentialString("uri")).To(Equal("postgres://db.example.com/orders"))
+		})
+
+		It("formats numbers and booleans", func() {
+			Expect(credentialString("port")).To(Equal("5432"))
+			Expect(credentialString("ssl")).To(Equal("true"))
+
+			service.Credentials = map[string]interface{}{"account": float64(12345678), "ratio": 0.25}
+			Expect(credentialString("account")).To(Equal("12345678"))
+			Expect(credentialString("ratio")).To(Equal("0.25"))
+		})
+
+		It("keeps every digit of large integers", func() {
+			services, err := libbuildpack.ParseVCAPServices(`{"user-provided":[{"name":"billing","credentials":{"account":9007199254740993}}]}`)
+			Expect(err).To(BeNil())
+
+			account, found := services[0].CredentialString("account")
+			Expect(found).To(BeTrue())
+			Expect(account).To(Equal("9007199254740993"))
+		})
+
+		It("returns the raw value", func() {
+			hosts, found := service.Credential("hosts")
+			Expect(found).To(BeTrue())
+			Expect(hosts).To(HaveLen(2))
+		})
+
+		It("reports missing paths", func() {
+			for _, path := range []string{"missing", "hosts.2.name", "hosts.first", "uri.scheme"} {
+				_, found := service.Credential(path)
+				Expect(found).To(BeFalse(), path)
+			}
+		})
+	})
+
+	Describe("LoadVCAPApplication", func() {
+		It("parses VCAP_APPLICATION", func() {
+			os.Setenv("VCAP_APPLICATION", `{"application_name": "orders", "space_name": "prod", "application_uris": ["orders.example.com"], "limits": {"mem": 1024}}`)
+
+			app, err := libbuildpack.LoadVCAPApplication()
+			Expect(err).To(BeNil())
+			Expect(app.ApplicationName).To(Equal("orders"))
+			Expect(app.SpaceName).To(Equal("prod"))
+			Expect(app.ApplicationURIs).To(Equal([]string{"orders.example.com"}))
+			Expect(app.Limits.Mem).To(Equal(1024))
+		})
+
+		It("returns the zero value when unset", func() {
+			Expect(libbuildpack.LoadVCAPApplication()).To(Equal(libbuildpack.VCAPApplication{}))
+		})
+
+		It("returns an error for invalid JSON", func() {
+			os.Setenv("VCAP_APPLICATION", `{`)
+
+			_, err := libbuildpack.LoadVCAPApplication()
+			Expect(err).To(MatchError(ContainSubstring("could not parse VCAP_APPLICATION")))
+		})
+	})
+})
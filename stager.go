@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const SENTINEL = "sentinel"
@@ -18,6 +20,12 @@ type Stager struct {
 	profileDir string
 	manifest   *Manifest
 	log        *Logger
+	hooks      *HookRegistry
+	started    time.Time
+	steps      []*StepReport
+	stepStack  []*StepReport
+
+	diskLimitWarned bool
 }
 
 func NewStager(args []string, logger *Logger, manifest *Manifest) *Stager {
@@ -52,6 +60,12 @@ func NewStager(args []string, logger *Logger, manifest *Manifest) *Stager {
 		profileDir: profileDir,
 		manifest:   manifest,
 		log:        logger,
+		hooks:      NewHookRegistry(),
+		started:    time.Now(),
+	}
+
+	if logger != nil {
+		RegisterStagingSecrets(logger)
 	}
 
 	return s
@@ -61,6 +75,12 @@ func (s *Stager) Logger() *Logger {
 	return s.log
 }
 
+// Hooks returns the hooks run for this Stager only, in addition to the ones
+// added with AddHook.
+func (s *Stager) Hooks() *HookRegistry {
+	return s.hooks
+}
+
 func (s *Stager) DepsDir() string {
 	return s.depsDir
 }
@@ -82,23 +102,22 @@ func (s *Stager) WriteConfigYml(config interface{}) error {
 		return err
 	}
 	data := map[string]interface{}{"name": s.manifest.Language(), "config": config, "version": bpVersion}
+	if Planning() {
+		return s.manifest.planWriteYAML(filepath.Join(s.DepDir(), "config.yml"), data)
+	}
 	y := &YAML{}
 	return y.Write(filepath.Join(s.DepDir(), "config.yml"), data)
 }
 
 func (s *Stager) WriteEnvFile(envVar, envVal string) error {
-	envDir := filepath.Join(s.DepDir(), "env")
-
-	if err := os.MkdirAll(envDir, 0755); err != nil {
-		return err
-
-	}
-
-	return os.WriteFile(filepath.Join(envDir, envVar), []byte(envVal), 0644)
+	return s.writeDepEnvFile(envBothDir, envVar, envVal)
 }
 
 func (s *Stager) LinkDirectoryInDepDir(destDir, depSubDir string) error {
 	srcDir := filepath.Join(s.DepDir(), depSubDir)
+	if Planning() {
+		return s.manifest.recordPlanAction(PlanLinkDirectory, srcDir, map[string]string{"source": destDir})
+	}
 	if err := os.MkdirAll(srcDir, 0755); err != nil {
 		return err
 	}
@@ -147,7 +166,14 @@ func (s *Stager) CheckBuildpackValid() error {
 	return nil
 }
 
+// StagingComplete records the dependencies installed so far in <DepDir>,
+// together with those an earlier phase recorded there, and stores them with
+// the buildpack metadata in the cache dir. SupplyMain and FinalizeMain call it
+// once the phase succeeded.
 func (s *Stager) StagingComplete() {
+	if err := s.syncInstalledDependencies(); err != nil {
+		s.log.Warning("Unable to record installed dependencies: %s", err)
+	}
 	s.manifest.StoreBuildpackMetadata(s.cacheDir)
 }
 
@@ -190,6 +216,10 @@ func (s *Stager) ClearDepDir() error {
 func (s *Stager) WriteProfileD(scriptName, scriptContents string) error {
 	profileDir := filepath.Join(s.DepDir(), "profile.d")
 
+	if Planning() {
+		return s.manifest.recordPlanAction(PlanWriteProfileD, filepath.Join(profileDir, scriptName), map[string]string{"contents": scriptContents})
+	}
+
 	err := os.MkdirAll(profileDir, 0755)
 	if err != nil {
 		return err
@@ -239,7 +269,7 @@ func (s *Stager) SetStagingEnvironment() error {
 		}
 
 		for _, file := range files {
-			if file.Type().IsRegular() {
+			if file.Type().IsRegular() && !isEnvModifierFile(file.Name()) {
 				val, err := os.ReadFile(filepath.Join(dir, file.Name()))
 				if err != nil {
 					return err
@@ -252,9 +282,24 @@ func (s *Stager) SetStagingEnvironment() error {
 		}
 	}
 
+	mods, err := envModifications(s.depsDir, stagingEnvFileDirs)
+	if err != nil {
+		return err
+	}
+
+	for _, mod := range mods {
+		if err := os.Setenv(mod.name, mod.apply(os.Getenv(mod.name))); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// SetLaunchEnvironment writes the multi-supply profile.d script, which applies
+// the env files of every buildpack at launch, and copies the profile.d scripts
+// of every buildpack. Paths in the deps dir in env file values refer to
+// DEPS_DIR in the script, since the deps dir moves after staging.
 func (s *Stager) SetLaunchEnvironment() error {
 	scriptContents := ""
 
@@ -270,13 +315,28 @@ func (s *Stager) SetLaunchEnvironment() error {
 		}
 	}
 
-	if err := os.MkdirAll(s.profileDir, 0755); err != nil {
+	mods, err := envModifications(s.depsDir, launchEnvFileDirs)
+	if err != nil {
 		return err
 	}
 
+	for _, mod := range mods {
+		scriptContents += envModificationScriptLine(mod, s.depsDir)
+		scriptContents += "\n"
+	}
+
 	scriptLocation := filepath.Join(s.ProfileDir(), scriptName)
-	if err := writeToFile(strings.NewReader(scriptContents), scriptLocation, 0755); err != nil {
-		return err
+	if Planning() {
+		if err := s.manifest.recordPlanAction(PlanWriteProfileD, scriptLocation, map[string]string{"contents": scriptContents}); err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(s.profileDir, 0755); err != nil {
+			return err
+		}
+		if err := writeToFile(strings.NewReader(scriptContents), scriptLocation, 0755); err != nil {
+			return err
+		}
 	}
 
 	profileDirs, err := existingDepsDirs(s.depsDir, "profile.d", s.depsDir)
@@ -300,18 +360,67 @@ func (s *Stager) SetLaunchEnvironment() error {
 		for _, file := range files {
 			if file.Type().IsRegular() {
 				src := filepath.Join(dir, file.Name())
-				dest := filepath.Join(s.profileDir, depsIdx+"_"+file.Name())
+				dest := filepath.Join(s.profileDir, profileScriptDest(DefaultProfileWeight, depsIdx, file.Name()))
 
-				if err := CopyFile(src, dest); err != nil {
+				if err := s.copyProfileScript(src, dest); err != nil {
 					return err
 				}
 			}
 		}
+
+		if err := s.copyWeightedProfileScripts(filepath.Join(dir, "weighted"), depsIdx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Stager) copyWeightedProfileScripts(dir, depsIdx string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, file := range files {
+		prefix, name, found := strings.Cut(file.Name(), "_")
+		if !file.Type().IsRegular() || !found {
+			continue
+		}
+		weight, err := strconv.Atoi(prefix)
+		if err != nil {
+			continue
+		}
+
+		dest := filepath.Join(s.profileDir, profileScriptDest(weight, depsIdx, name))
+		if err := s.copyProfileScript(filepath.Join(dir, file.Name()), dest); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+func (s *Stager) copyProfileScript(src, dest string) error {
+	if Planning() {
+		return s.manifest.recordPlanAction(PlanWriteProfileD, dest, map[string]string{"source": src})
+	}
+	return CopyFile(src, dest)
+}
+
+// profileScriptDest names the copy of a profile.d script in the .profile.d
+// directory as <weight>_<idx>_<name>, both zero padded, so that scripts sort
+// by weight, then by buildpack, and all of them after the multi-supply script.
+func profileScriptDest(weight int, depsIdx, name string) string {
+	if idx, err := strconv.Atoi(depsIdx); err == nil {
+		depsIdx = fmt.Sprintf("%03d", idx)
+	}
+	return fmt.Sprintf("%03d_%s_%s", weight, depsIdx, name)
+}
+
 func (s *Stager) BuildpackLanguage() string {
 	return s.manifest.Language()
 }
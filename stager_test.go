@@ -621,16 +621,16 @@ var _ = Describe("Stager", func() {
 				}
 			})
 
-			It("copies scripts from <deps-dir>/<idx>/profile.d to the .profile.d directory, prepending <idx>", func() {
+			It("copies scripts from <deps-dir>/<idx>/profile.d to the .profile.d directory, prepending the default weight and <idx>", func() {
 				err = s.SetLaunchEnvironment()
 				Expect(err).To(BeNil())
 
-				contents, err := os.ReadFile(filepath.Join(profileDir, "00_supplied-script.sh"))
+				contents, err := os.ReadFile(filepath.Join(profileDir, "500_000_supplied-script.sh"))
 				Expect(err).To(BeNil())
 
 				Expect(string(contents)).To(Equal("first"))
 
-				contents, err = os.ReadFile(filepath.Join(profileDir, "01_supplied-script.sh"))
+				contents, err = os.ReadFile(filepath.Join(profileDir, "500_001_supplied-script.sh"))
 				Expect(err).To(BeNil())
 
 				Expect(string(contents)).To(Equal("second"))
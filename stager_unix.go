@@ -3,8 +3,10 @@
 package libbuildpack
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 const (
@@ -30,6 +32,9 @@ var launchEnvVarDirs = map[string]string{
 
 func (s *Stager) AddBinDependencyLink(destPath, sourceName string) error {
 	binDir := filepath.Join(s.DepDir(), "bin")
+	if Planning() {
+		return s.manifest.recordPlanAction(PlanSymlink, filepath.Join(binDir, sourceName), map[string]string{"source": destPath})
+	}
 
 	if err := os.MkdirAll(binDir, 0755); err != nil {
 		return err
@@ -42,3 +47,21 @@ func (s *Stager) AddBinDependencyLink(destPath, sourceName string) error {
 
 	return os.Symlink(relPath, filepath.Join(binDir, sourceName))
 }
+
+func envModificationScriptLine(mod envModification, depsDir string) string {
+	value := renderProfileValue(launchValueParts(mod.value, depsDir))
+	switch mod.modifier {
+	case EnvDefault:
+		return fmt.Sprintf(`if [[ -z "${%[1]s:-}" ]]; then export %[1]s=%[2]s; fi`, mod.name, value)
+	case EnvPrepend:
+		return fmt.Sprintf(`if [[ -n "${%[1]s:-}" ]]; then export %[1]s=%[2]s%[3]s"$%[1]s"; else export %[1]s=%[2]s; fi`, mod.name, value, shellQuote(mod.delim))
+	case EnvAppend:
+		return fmt.Sprintf(`if [[ -n "${%[1]s:-}" ]]; then export %[1]s="$%[1]s"%[3]s%[2]s; else export %[1]s=%[2]s; fi`, mod.name, value, shellQuote(mod.delim))
+	}
+	return fmt.Sprintf("export %s=%s", mod.name, value)
+}
+
+// shellQuote wraps s in single quotes so the shell uses it verbatim.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -3,8 +3,10 @@
 package libbuildpack
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 const (
@@ -24,6 +26,9 @@ var launchEnvVarDirs = map[string]string{
 
 func (s *Stager) AddBinDependencyLink(destPath, sourceName string) error {
 	binDir := filepath.Join(s.DepDir(), "bin")
+	if Planning() {
+		return s.manifest.recordPlanAction(PlanLink, filepath.Join(binDir, sourceName), map[string]string{"source": destPath})
+	}
 
 	if err := os.MkdirAll(binDir, 0755); err != nil {
 		return err
@@ -31,3 +36,55 @@ func (s *Stager) AddBinDependencyLink(destPath, sourceName string) error {
 
 	return os.Link(destPath, filepath.Join(binDir, sourceName))
 }
+
+func envModificationScriptLine(mod envModification, depsDir string) string {
+	name := profileValuePart{text: mod.name, ref: true}
+	value := launchValueParts(mod.value, depsDir)
+	delim := profileValuePart{text: mod.delim}
+
+	switch mod.modifier {
+	case EnvDefault:
+		return fmt.Sprintf(`if not defined %s set %s`, mod.name, batchSetArg(mod.name, value...))
+	case EnvPrepend:
+		prepended := append(append([]profileValuePart{}, value...), delim, name)
+		return fmt.Sprintf(`if defined %s (set %s) else (set %s)`, mod.name, batchSetArg(mod.name, prepended...), batchSetArg(mod.name, value...))
+	case EnvAppend:
+		appended := append([]profileValuePart{name, delim}, value...)
+		return fmt.Sprintf(`if defined %s (set %s) else (set %s)`, mod.name, batchSetArg(mod.name, appended...), batchSetArg(mod.name, value...))
+	}
+	return "set " + batchSetArg(mod.name, value...)
+}
+
+// batchSetArg returns the argument of a set command that sets name to parts,
+// where refs are expanded by cmd. The argument is quoted, which keeps special
+// characters in expanded values intact, unless the literal text has double
+// quotes, which cmd cannot escape inside quotes. Those arguments are caret
+// escaped instead. Percent signs are doubled either way.
+func batchSetArg(name string, parts ...profileValuePart) string {
+	quoted := true
+	for _, part := range parts {
+		if !part.ref && strings.Contains(part.text, `"`) {
+			quoted = false
+		}
+	}
+
+	escape := strings.NewReplacer("%", "%%")
+	if !quoted {
+		escape = strings.NewReplacer("%", "%%", "^", "^^", `"`, `^"`, "&", "^&", "|", "^|", "<", "^<", ">", "^>", "(", "^(", ")", "^)")
+	}
+
+	var arg strings.Builder
+	arg.WriteString(name + "=")
+	for _, part := range parts {
+		if part.ref {
+			arg.WriteString("%" + part.text + "%")
+		} else {
+			arg.WriteString(escape.Replace(part.text))
+		}
+	}
+
+	if quoted {
+		return `"` + arg.String() + `"`
+	}
+	return arg.String()
+}
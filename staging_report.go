@@ -0,0 +1,165 @@
+package libbuildpack
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const stagingReportFile = "staging-report.json"
+
+// StagingReport is written to <DepDir>/staging-report.json at the end of
+// supply and finalize, so platforms can aggregate how staging went. When a
+// buildpack both supplies and finalizes, the report covers both phases.
+type StagingReport struct {
+	Buildpack    string                  `json:"buildpack"`
+	Version      string                  `json:"version"`
+	Stack        string                  `json:"stack"`
+	Started      time.Time               `json:"started"`
+	Seconds      float64                 `json:"seconds"`
+	Steps        []*StepReport           `json:"steps"`
+	Warnings     []string                `json:"warnings"`
+	Dependencies []DependencyFingerprint `json:"dependencies"`
+}
+
+type StepReport struct {
+	Name      string        `json:"name"`
+	Seconds   float64       `json:"seconds"`
+	Error     string        `json:"error,omitempty"`
+	DiskUsage *DiskUsage    `json:"disk_usage,omitempty"`
+	Steps     []*StepReport `json:"steps,omitempty"`
+}
+
+// Step logs name, runs fn and records how long it took. Steps run inside fn
+// are nested under this one; the outermost steps are logged with BeginStep.
+// If DiskLimitEnv is set, outermost steps also record the disk usage after
+// fn and warn the first time it exceeds the limit.
+func (s *Stager) Step(name string, fn func() error) error {
+	step := &StepReport{Name: name}
+
+	depth := len(s.stepStack)
+	s.log.beginStep(depth, name)
+	if depth == 0 {
+		s.steps = append(s.steps, step)
+	} else {
+		parent := s.stepStack[depth-1]
+		parent.Steps = append(parent.Steps, step)
+	}
+
+	s.stepStack = append(s.stepStack, step)
+	defer func() {
+		s.stepStack = s.stepStack[:len(s.stepStack)-1]
+		s.log.endStep(depth)
+	}()
+
+	start := time.Now()
+	err := fn()
+	step.Seconds = time.Since(start).Seconds()
+	if err != nil {
+		step.Error = err.Error()
+	}
+
+	if depth == 0 {
+		s.measureStepDiskUsage(step)
+	}
+	return err
+}
+
+func (s *Stager) measureStepDiskUsage(step *StepReport) {
+	if limit, err := DiskLimit(); err != nil || limit == 0 {
+		return
+	}
+
+	var usage DiskUsage
+	var err error
+	if s.diskLimitWarned {
+		usage, err = s.DiskUsage()
+	} else {
+		usage, s.diskLimitWarned, err = s.checkDiskUsage()
+	}
+	if err != nil {
+		s.log.Debug("Unable to measure disk usage: %s", err)
+		return
+	}
+	step.DiskUsage = &usage
+}
+
+// StagingReport returns the steps, warnings and installed dependencies
+// recorded by this process so far.
+func (s *Stager) StagingReport() StagingReport {
+	version, _ := s.manifest.Version()
+
+	warnings := s.log.Warnings()
+	if warnings == nil {
+		warnings = []string{}
+	}
+	dependencies := s.manifest.InstalledDependencies()
+	if dependencies == nil {
+		dependencies = []DependencyFingerprint{}
+	}
+	steps := s.steps
+	if steps == nil {
+		steps = []*StepReport{}
+	}
+
+	return StagingReport{
+		Buildpack:    s.manifest.Language(),
+		Version:      version,
+		Stack:        os.Getenv("CF_STACK"),
+		Started:      s.started,
+		Seconds:      time.Since(s.started).Seconds(),
+		Steps:        steps,
+		Warnings:     warnings,
+		Dependencies: dependencies,
+	}
+}
+
+// WriteStagingReport logs the timings of the steps run so far and writes the
+// staging report to <DepDir>/staging-report.json, added to the report of an
+// earlier phase if there is one. SupplyMain and FinalizeMain
+// call it once the phase succeeds.
+func (s *Stager) WriteStagingReport() error {
+	report := s.StagingReport()
+
+	if len(report.Steps) != 0 {
+		s.log.BeginStep("Staging timings")
+		logStepTimings(s.log, report.Steps, 0)
+	}
+
+	if Planning() {
+		return nil
+	}
+
+	file := filepath.Join(s.DepDir(), stagingReportFile)
+	var earlier StagingReport
+	if err := NewJSON().Load(file, &earlier); err == nil {
+		report = earlier.merge(report)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return NewJSON().Write(file, report)
+}
+
+// merge adds the report of a later phase to r. The buildpack, stack and
+// start are those of r. The later phase also reports the dependencies of the
+// earlier one, so those are only listed once.
+func (r StagingReport) merge(later StagingReport) StagingReport {
+	r.Seconds += later.Seconds
+	r.Steps = append(r.Steps, later.Steps...)
+	r.Warnings = append(r.Warnings, later.Warnings...)
+	for _, dep := range later.Dependencies {
+		if !hasFingerprint(r.Dependencies, dep) {
+			r.Dependencies = append(r.Dependencies, dep)
+		}
+	}
+	return r
+}
+
+func logStepTimings(logger *Logger, steps []*StepReport, depth int) {
+	for _, step := range steps {
+		duration := time.Duration(step.Seconds * float64(time.Second)).Round(time.Millisecond)
+		logger.Info("%s%s: %s", strings.Repeat("  ", depth), step.Name, duration)
+		logStepTimings(logger, step.Steps, depth+1)
+	}
+}
@@ -0,0 +1,153 @@
+package libbuildpack_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Staging report", func() {
+	var (
+		depsDir string
+		buffer  *bytes.Buffer
+		logger  *libbuildpack.Logger
+		stager  *libbuildpack.Stager
+	)
+
+	BeforeEach(func() {
+		DeferCleanup(os.Setenv, "CF_STACK", os.Getenv("CF_STACK"))
+		os.Setenv("CF_STACK", "cflinuxfs2")
+
+		var err error
+		depsDir, err = os.MkdirTemp("", "deps")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, depsDir)
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+
+		buffer = new(bytes.Buffer)
+		logger = libbuildpack.NewLogger(buffer)
+		manifest, err := libbuildpack.NewManifest(filepath.Join("fixtures", "manifest", "standard"), logger, time.Now())
+		Expect(err).To(BeNil())
+		stager = libbuildpack.NewStager([]string{"", "", depsDir, "0"}, logger, manifest)
+	})
+
+	Describe("Step", func() {
+		It("logs and times nested steps", func() {
+			Expect(stager.Step("Installing node", func() error {
+				return stager.Step("Extracting", func() error {
+					return stager.Step("Verifying", func() error { return nil })
+				})
+			})).To(Succeed())
+
+			Expect(buffer.String()).To(Equal("-----> Installing node\n       Extracting\n         Verifying\n"))
+
+			steps := stager.StagingReport().Steps
+			Expect(steps).To(HaveLen(1))
+			Expect(steps[0].Name).To(Equal("Installing node"))
+			Expect(steps[0].Steps).To(HaveLen(1))
+			Expect(steps[0].Steps[0].Name).To(Equal("Extracting"))
+			Expect(steps[0].Steps[0].Steps[0].Name).To(Equal("Verifying"))
+		})
+
+		It("nests the steps of JSON logs", func() {
+			stager = libbuildpack.NewStager([]string{"", "", depsDir, "0"}, libbuildpack.NewJSONLogger(buffer), nil)
+			Expect(stager.Step("Installing node", func() error {
+				return stager.Step("Extracting", func() error {
+					stager.Logger().Info("extracted")
+					return nil
+				})
+			})).To(Succeed())
+			stager.Logger().Info("done")
+
+			type logEvent struct {
+				Message string
+				Step    []string
+			}
+			var events []logEvent
+			for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+				var event logEvent
+				Expect(json.Unmarshal([]byte(line), &event)).To(Succeed())
+				events = append(events, event)
+			}
+			Expect(events).To(HaveLen(4))
+			Expect(events[1].Step).To(Equal([]string{"Installing node", "Extracting"}))
+			Expect(events[2].Step).To(Equal([]string{"Installing node", "Extracting"}))
+			Expect(events[3].Step).To(BeEmpty())
+		})
+
+		It("returns and records the error of fn", func() {
+			err := stager.Step("Installing node", func() error { return errors.New("no node") })
+			Expect(err).To(MatchError("no node"))
+			Expect(stager.StagingReport().Steps[0].Error).To(Equal("no node"))
+
+			Expect(stager.Step("Next", func() error { return nil })).To(Succeed())
+			Expect(stager.StagingReport().Steps).To(HaveLen(2))
+		})
+	})
+
+	Describe("WriteStagingReport", func() {
+		It("logs the timings and writes staging-report.json to the dep dir", func() {
+			Expect(stager.Step("Installing node", func() error { return nil })).To(Succeed())
+			logger.Warning("node is old")
+
+			Expect(stager.WriteStagingReport()).To(Succeed())
+			Expect(buffer.String()).To(MatchRegexp(`-----> Staging timings\n       Installing node: \d+m?s\n`))
+
+			var report libbuildpack.StagingReport
+			Expect(libbuildpack.NewJSON().Load(filepath.Join(depsDir, "0", "staging-report.json"), &report)).To(Succeed())
+			Expect(report.Buildpack).To(Equal("dotnet-core"))
+			Expect(report.Version).To(Equal("99.99"))
+			Expect(report.Stack).To(Equal("cflinuxfs2"))
+			Expect(report.Steps).To(HaveLen(1))
+			Expect(report.Steps[0].Name).To(Equal("Installing node"))
+			Expect(report.Warnings).To(Equal([]string{"node is old"}))
+			Expect(report.Dependencies).To(BeEmpty())
+		})
+
+		It("adds to the report of an earlier phase", func() {
+			Expect(stager.Step("Supplying", func() error { return nil })).To(Succeed())
+			logger.Warning("supply warning")
+			Expect(stager.WriteStagingReport()).To(Succeed())
+
+			var supplied libbuildpack.StagingReport
+			Expect(libbuildpack.NewJSON().Load(filepath.Join(depsDir, "0", "staging-report.json"), &supplied)).To(Succeed())
+
+			finalizeLogger := libbuildpack.NewLogger(new(bytes.Buffer))
+			manifest, err := libbuildpack.NewManifest(filepath.Join("fixtures", "manifest", "standard"), finalizeLogger, time.Now())
+			Expect(err).To(BeNil())
+			finalizer := libbuildpack.NewStager([]string{"", "", depsDir, "0"}, finalizeLogger, manifest)
+			Expect(finalizer.Step("Finalizing", func() error { return nil })).To(Succeed())
+			finalizeLogger.Warning("finalize warning")
+			Expect(finalizer.WriteStagingReport()).To(Succeed())
+
+			var report libbuildpack.StagingReport
+			Expect(libbuildpack.NewJSON().Load(filepath.Join(depsDir, "0", "staging-report.json"), &report)).To(Succeed())
+			Expect(report.Started).To(Equal(supplied.Started))
+			Expect(report.Seconds).To(BeNumerically(">=", supplied.Seconds))
+			Expect(report.Steps).To(HaveLen(2))
+			Expect(report.Steps[0].Name).To(Equal("Supplying"))
+			Expect(report.Steps[1].Name).To(Equal("Finalizing"))
+			Expect(report.Warnings).To(Equal([]string{"supply warning", "finalize warning"}))
+		})
+
+		It("is written by SupplyMain", func() {
+			DeferCleanup(os.Setenv, "BUILDPACK_DIR", os.Getenv("BUILDPACK_DIR"))
+			os.Setenv("BUILDPACK_DIR", filepath.Join("fixtures", "manifest", "standard"))
+
+			code := libbuildpack.SupplyMain([]string{"", "", depsDir, "0"}, logger, func(ctx *libbuildpack.SupplyContext) error {
+				return ctx.Stager.Step("Supplying", func() error { return nil })
+			})
+			Expect(code).To(Equal(0))
+			Expect(filepath.Join(depsDir, "0", "staging-report.json")).To(BeARegularFile())
+		})
+	})
+})
@@ -0,0 +1,78 @@
+package libbuildpack
+
+import (
+	"os"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// SuppliedBuildpack describes a buildpack that ran before this one in a
+// multi-buildpack chain, as recorded in its <DepDir>/config.yml.
+type SuppliedBuildpack struct {
+	Index   string
+	Name    string
+	Version string
+	// Config is the config passed to WriteConfigYml, as decoded by yaml.v2.
+	Config interface{}
+	// BinDir and LibDir are set if the buildpack created them.
+	BinDir string
+	LibDir string
+}
+
+// DecodeConfig decodes Config into obj, which is typically a pointer to a
+// struct with yaml tags.
+func (b SuppliedBuildpack) DecodeConfig(obj interface{}) error {
+	data, err := yaml.Marshal(b.Config)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, obj)
+}
+
+// SuppliedBuildpacks returns the buildpacks that wrote a config.yml to the
+// deps dir, ordered by index.
+func (s *Stager) SuppliedBuildpacks() ([]SuppliedBuildpack, error) {
+	idxs, err := depsIdxs(s.depsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var buildpacks []SuppliedBuildpack
+	for _, idx := range idxs {
+		depDir := filepath.Join(s.depsDir, idx)
+
+		var config struct {
+			Name    string      `yaml:"name"`
+			Version string      `yaml:"version"`
+			Config  interface{} `yaml:"config"`
+		}
+		if err := NewYAML().Load(filepath.Join(depDir, "config.yml"), &config); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		buildpack := SuppliedBuildpack{
+			Index:   idx,
+			Name:    config.Name,
+			Version: config.Version,
+			Config:  config.Config,
+		}
+		if exists, err := FileExists(filepath.Join(depDir, "bin")); err != nil {
+			return nil, err
+		} else if exists {
+			buildpack.BinDir = filepath.Join(depDir, "bin")
+		}
+		if exists, err := FileExists(filepath.Join(depDir, "lib")); err != nil {
+			return nil, err
+		} else if exists {
+			buildpack.LibDir = filepath.Join(depDir, "lib")
+		}
+
+		buildpacks = append(buildpacks, buildpack)
+	}
+
+	return buildpacks, nil
+}
@@ -0,0 +1,106 @@
+package libbuildpack_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SuppliedBuildpacks", func() {
+	var (
+		depsDir string
+		s       *libbuildpack.Stager
+		err     error
+	)
+
+	writeConfig := func(idx, contents string) {
+		Expect(os.MkdirAll(filepath.Join(depsDir, idx), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(depsDir, idx, "config.yml"), []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		depsDir, err = os.MkdirTemp("", "deps")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, depsDir)
+
+		logger := libbuildpack.NewLogger(new(bytes.Buffer))
+		manifest, err := libbuildpack.NewManifest(filepath.Join("fixtures", "manifest", "standard"), logger, time.Now())
+		Expect(err).To(BeNil())
+
+		s = libbuildpack.NewStager([]string{"", "", depsDir, "11"}, logger, manifest)
+
+		writeConfig("0", "name: java\nversion: 4.50.0\nconfig:\n  jdk:\n    version: 17.0.9\n    home: /deps/0/jdk\n")
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0", "bin"), 0755)).To(Succeed())
+		writeConfig("10", "name: nodejs\nversion: 1.8.20\nconfig: {}\n")
+		Expect(os.MkdirAll(filepath.Join(depsDir, "10", "lib"), 0755)).To(Succeed())
+		writeConfig("2", "name: python\nversion: 1.8.18\n")
+		Expect(os.MkdirAll(filepath.Join(depsDir, "11"), 0755)).To(Succeed())
+	})
+
+	It("returns buildpacks that wrote a config.yml in index order", func() {
+		buildpacks, err := s.SuppliedBuildpacks()
+		Expect(err).To(BeNil())
+
+		Expect(buildpacks).To(HaveLen(3))
+		Expect(buildpacks[0].Index).To(Equal("0"))
+		Expect(buildpacks[0].Name).To(Equal("java"))
+		Expect(buildpacks[0].Version).To(Equal("4.50.0"))
+		Expect(buildpacks[1].Index).To(Equal("2"))
+		Expect(buildpacks[1].Name).To(Equal("python"))
+		Expect(buildpacks[2].Index).To(Equal("10"))
+		Expect(buildpacks[2].Name).To(Equal("nodejs"))
+	})
+
+	It("exposes existing bin and lib dirs", func() {
+		buildpacks, err := s.SuppliedBuildpacks()
+		Expect(err).To(BeNil())
+
+		Expect(buildpacks[0].BinDir).To(Equal(filepath.Join(depsDir, "0", "bin")))
+		Expect(buildpacks[0].LibDir).To(BeEmpty())
+		Expect(buildpacks[2].BinDir).To(BeEmpty())
+		Expect(buildpacks[2].LibDir).To(Equal(filepath.Join(depsDir, "10", "lib")))
+	})
+
+	It("decodes config into a typed struct", func() {
+		buildpacks, err := s.SuppliedBuildpacks()
+		Expect(err).To(BeNil())
+
+		var config struct {
+			JDK struct {
+				Version string `yaml:"version"`
+				Home    string `yaml:"home"`
+			} `yaml:"jdk"`
+		}
+		Expect(buildpacks[0].DecodeConfig(&config)).To(Succeed())
+		Expect(config.JDK.Version).To(Equal("17.0.9"))
+		Expect(config.JDK.Home).To(Equal("/deps/0/jdk"))
+	})
+
+	It("reads back what WriteConfigYml wrote", func() {
+		Expect(s.WriteConfigYml(map[string]string{"runtime": "dotnet"})).To(Succeed())
+
+		buildpacks, err := s.SuppliedBuildpacks()
+		Expect(err).To(BeNil())
+		Expect(buildpacks).To(HaveLen(4))
+		Expect(buildpacks[3].Index).To(Equal("11"))
+		Expect(buildpacks[3].Name).To(Equal("dotnet-core"))
+		Expect(buildpacks[3].Version).To(Equal("99.99"))
+
+		var config map[string]string
+		Expect(buildpacks[3].DecodeConfig(&config)).To(Succeed())
+		Expect(config).To(Equal(map[string]string{"runtime": "dotnet"}))
+	})
+
+	It("returns an error for an invalid config.yml", func() {
+		writeConfig("3", "name: [unterminated")
+
+		_, err := s.SuppliedBuildpacks()
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,94 @@
+package libbuildpack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Env vars with the time budget of each download and command, e.g. 10m.
+// Operations have no budget unless one is set. HookTimeoutEnv, declared with
+// the executable hooks, sets the budget of hooks the same way.
+const (
+	DownloadTimeoutEnv = "BP_DOWNLOAD_TIMEOUT"
+	CommandTimeoutEnv  = "BP_COMMAND_TIMEOUT"
+)
+
+// TimeoutError names the operation that did not finish within its budget, or
+// before the deadline of the context it ran with if Timeout is 0.
+type TimeoutError struct {
+	Operation string
+	Timeout   time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Timeout == 0 {
+		return fmt.Sprintf("%s did not finish before its deadline", e.Operation)
+	}
+	return fmt.Sprintf("%s did not finish within %s", e.Operation, e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// OperationTimeout returns the budget set in env, or 0 if there is none.
+func OperationTimeout(env string) (time.Duration, error) {
+	value := os.Getenv(env)
+	if value == "" {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid %s: %q is not a duration", env, value)
+	}
+	return timeout, nil
+}
+
+// operation is a named piece of staging with the budget configured in an env
+// var.
+type operation struct {
+	name    string
+	timeout time.Duration
+	start   time.Time
+}
+
+// startOperation returns ctx limited to the budget set in env.
+func startOperation(ctx context.Context, name, env string) (context.Context, context.CancelFunc, *operation, error) {
+	timeout, err := OperationTimeout(env)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	op := &operation{name: name, timeout: timeout, start: time.Now()}
+	if timeout == 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, op, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, op, nil
+}
+
+// result turns err into a TimeoutError if ctx expired, and names the
+// operation if ctx was canceled.
+func (o *operation) result(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		// An earlier deadline of the caller's context may have expired first.
+		if o.timeout != 0 && time.Since(o.start) >= o.timeout {
+			return &TimeoutError{Operation: o.name, Timeout: o.timeout}
+		}
+		return &TimeoutError{Operation: o.name}
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return fmt.Errorf("%s was canceled", o.name)
+	}
+	return err
+}
@@ -0,0 +1,235 @@
+package libbuildpack_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+	httpmock "github.com/jarcoal/httpmock"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Timeouts", func() {
+	var buffer *bytes.Buffer
+
+	BeforeEach(func() {
+		buffer = new(bytes.Buffer)
+		for _, env := range []string{libbuildpack.CommandTimeoutEnv, libbuildpack.DownloadTimeoutEnv, libbuildpack.HookTimeoutEnv} {
+			DeferCleanup(os.Setenv, env, os.Getenv(env))
+			os.Unsetenv(env)
+		}
+	})
+
+	Describe("OperationTimeout", func() {
+		It("is 0 when the env var is not set", func() {
+			Expect(libbuildpack.OperationTimeout(libbuildpack.CommandTimeoutEnv)).To(Equal(time.Duration(0)))
+		})
+
+		It("parses the env var", func() {
+			os.Setenv(libbuildpack.CommandTimeoutEnv, "2m")
+			Expect(libbuildpack.OperationTimeout(libbuildpack.CommandTimeoutEnv)).To(Equal(2 * time.Minute))
+		})
+
+		It("rejects values that are not durations", func() {
+			os.Setenv(libbuildpack.CommandTimeoutEnv, "soon")
+			_, err := libbuildpack.OperationTimeout(libbuildpack.CommandTimeoutEnv)
+			Expect(err).To(MatchError(`invalid BP_COMMAND_TIMEOUT: "soon" is not a duration`))
+		})
+	})
+
+	Describe("Command", func() {
+		var cmd libbuildpack.Command
+
+		BeforeEach(func() {
+			if runtime.GOOS == "windows" {
+				Skip("uses sleep")
+			}
+		})
+
+		It("kills commands that run longer than BP_COMMAND_TIMEOUT", func() {
+			os.Setenv(libbuildpack.CommandTimeoutEnv, "100ms")
+
+			start := time.Now()
+			err := cmd.Execute("", buffer, buffer, "sleep", "10")
+			Expect(err).To(MatchError("command sleep 10 did not finish within 100ms"))
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		})
+
+		It("kills commands when the context is canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+
+			_, err := cmd.OutputContext(ctx, "", "sleep", "10")
+			Expect(err).To(MatchError("command sleep 10 was canceled"))
+		})
+
+		It("keeps the errors of commands that finish in time", func() {
+			os.Setenv(libbuildpack.CommandTimeoutEnv, "10s")
+
+			Expect(cmd.ExecuteContext(context.Background(), "", buffer, buffer, "sh", "-c", "exit 3")).To(MatchError("exit status 3"))
+		})
+	})
+
+	Describe("Installer", func() {
+		var (
+			installer  *libbuildpack.Installer
+			outputFile string
+			dep        = libbuildpack.Dependency{Name: "ruby", Version: "2.3.3"}
+		)
+
+		BeforeEach(func() {
+			DeferCleanup(os.Setenv, "CF_STACK", os.Getenv("CF_STACK"))
+			os.Setenv("CF_STACK", "cflinuxfs2")
+			httpmock.Reset()
+
+			tmpDir, err := os.MkdirTemp("", "downloads")
+			Expect(err).To(BeNil())
+			DeferCleanup(os.RemoveAll, tmpDir)
+			outputFile = filepath.Join(tmpDir, "out.tgz")
+
+			manifest, err := libbuildpack.NewManifest(filepath.Join("fixtures", "manifest", "standard"), libbuildpack.NewLogger(buffer), time.Now())
+			Expect(err).To(BeNil())
+			installer = libbuildpack.NewInstaller(manifest)
+			installer.SetRetryTimeLimit(10 * time.Millisecond)
+			installer.SetRetryTimeInitialInterval(1 * time.Millisecond)
+
+			httpmock.RegisterResponder("GET", "https://buildpacks.cloudfoundry.org/dependencies/manual-binaries/dotnet/libunwind-1.2-linux-x64.tgz",
+				func(req *http.Request) (*http.Response, error) {
+					<-req.Context().Done()
+					return nil, req.Context().Err()
+				})
+		})
+
+		It("stops downloads that run longer than BP_DOWNLOAD_TIMEOUT", func() {
+			os.Setenv(libbuildpack.DownloadTimeoutEnv, "100ms")
+
+			err := installer.FetchDependency(dep, outputFile)
+			Expect(err).To(MatchError("downloading ruby 2.3.3 did not finish within 100ms"))
+			Expect(outputFile).NotTo(BeAnExistingFile())
+		})
+
+		It("stops downloads when the context expires", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			err := installer.FetchDependencyContext(ctx, dep, outputFile)
+			Expect(err).To(MatchError("downloading ruby 2.3.3 did not finish before its deadline"))
+		})
+	})
+
+	Describe("hooks", func() {
+		BeforeEach(func() {
+			libbuildpack.ClearHooks()
+			DeferCleanup(libbuildpack.ClearHooks)
+		})
+
+		It("fails hooks that run longer than BP_HOOK_TIMEOUT, and runs no more hooks", func() {
+			os.Setenv(libbuildpack.HookTimeoutEnv, "50ms")
+			libbuildpack.AddHook(slowHook{delay: 100 * time.Millisecond})
+			var events []string
+			libbuildpack.AddHook(&recordingHook{name: "later", events: &events})
+
+			Expect(libbuildpack.RunBeforeCompile(nil)).To(MatchError("BeforeCompile hook slow did not finish within 50ms"))
+			Expect(events).To(BeEmpty())
+		})
+
+		It("stops waiting for hooks that never return", func() {
+			os.Setenv(libbuildpack.HookTimeoutEnv, "50ms")
+			release := make(chan struct{})
+			DeferCleanup(func() { close(release) })
+			libbuildpack.AddHook(stuckHook{release: release})
+
+			err := libbuildpack.RunBeforeCompile(nil)
+			Expect(err).To(MatchError("BeforeCompile hook stuck did not finish within 50ms"))
+			var timeoutErr *libbuildpack.TimeoutError
+			Expect(errors.As(err, &timeoutErr)).To(BeTrue())
+		})
+
+		It("stops when the context is canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			libbuildpack.AddHook(slowHook{delay: 10 * time.Millisecond})
+
+			Expect(libbuildpack.RunAfterCompileContext(ctx, nil)).To(MatchError("AfterCompile hook slow was canceled"))
+		})
+
+		It("passes the context to context hooks", func() {
+			os.Setenv(libbuildpack.HookTimeoutEnv, "50ms")
+			hook := &contextHook{}
+			libbuildpack.AddHook(hook)
+
+			Expect(libbuildpack.RunBeforeCompile(nil)).To(MatchError("BeforeCompile hook context did not finish within 50ms"))
+			Expect(hook.Err()).To(Equal(context.DeadlineExceeded))
+		})
+	})
+})
+
+type slowHook struct {
+	libbuildpack.DefaultHook
+	delay time.Duration
+}
+
+func (h slowHook) Name() string { return "slow" }
+
+func (h slowHook) BeforeCompile(*libbuildpack.Stager) error {
+	time.Sleep(h.delay)
+	return nil
+}
+
+func (h slowHook) AfterCompile(stager *libbuildpack.Stager) error { return h.BeforeCompile(stager) }
+
+type stuckHook struct {
+	libbuildpack.DefaultHook
+	release chan struct{}
+}
+
+func (h stuckHook) Name() string { return "stuck" }
+
+func (h stuckHook) BeforeCompile(*libbuildpack.Stager) error {
+	<-h.release
+	return nil
+}
+
+type contextHook struct {
+	libbuildpack.DefaultHook
+	done chan error
+}
+
+func (h *contextHook) Name() string { return "context" }
+
+func (h *contextHook) WithContext(ctx context.Context) libbuildpack.Hook {
+	h.done = make(chan error, 1)
+	return &contextHookRun{ctx: ctx, done: h.done}
+}
+
+// Err returns the error the last run stopped with, or nil while it runs.
+func (h *contextHook) Err() error {
+	select {
+	case err := <-h.done:
+		h.done <- err
+		return err
+	default:
+		return nil
+	}
+}
+
+type contextHookRun struct {
+	libbuildpack.DefaultHook
+	ctx  context.Context
+	done chan error
+}
+
+func (h *contextHookRun) BeforeCompile(*libbuildpack.Stager) error {
+	<-h.ctx.Done()
+	h.done <- h.ctx.Err()
+	return h.ctx.Err()
+}
@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"archive/zip"
 	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
@@ -520,7 +521,7 @@ func CheckSha256(filePath, expectedSha256 string) error {
 	return nil
 }
 
-func downloadFile(url string, destFile string, retryTimeLimit time.Duration, retryTimeInitialInterval time.Duration, logger *Logger) error {
+func downloadFile(ctx context.Context, url string, destFile string, retryTimeLimit time.Duration, retryTimeInitialInterval time.Duration, logger *Logger) error {
 	bo := backoff.NewExponentialBackOff()
 	bo.MaxElapsedTime = retryTimeLimit
 	bo.InitialInterval = retryTimeInitialInterval
@@ -529,8 +530,12 @@ func downloadFile(url string, destFile string, retryTimeLimit time.Duration, ret
 	var err error
 
 	operation := func() error {
-		resp, err = http.Get(url)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return backoff.Permanent(err)
+		}
 
+		resp, err = http.DefaultClient.Do(req)
 		if err != nil {
 			return err
 		}
@@ -546,7 +551,7 @@ func downloadFile(url string, destFile string, retryTimeLimit time.Duration, ret
 		logger.Info("error: %v, retrying in %v...", err, duration)
 	}
 
-	err = backoff.RetryNotify(operation, bo, notify)
+	err = backoff.RetryNotify(operation, backoff.WithContext(bo, ctx), notify)
 
 	if err != nil {
 		return fmt.Errorf("could not download: %s", err)